@@ -0,0 +1,212 @@
+package pee
+
+import (
+	"context"
+	"sync"
+)
+
+var ErrExecutorClosed = makeErr("executor is closed")
+
+// Executor executes instances of an Automata asynchronously in a bounded pool of goroutines.
+type Executor[TxContext context.Context, R any] struct {
+	automata *Automata[TxContext, R]
+	runInTx  RunInTx[TxContext, Instance]
+
+	// a slot is taken for every running execution
+	slots chan struct{}
+
+	// closed by Close, so waiting for a slot stops
+	closing chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	running map[*Handle[R]]struct{}
+	wg      sync.WaitGroup
+}
+
+// NewExecutor creates a new Executor for the given Automata that runs
+// at most size executions concurrently. Every transition is applied in a new
+// transaction created by runInTx.
+func NewExecutor[TxContext context.Context, R any](automata *Automata[TxContext, R], runInTx RunInTx[TxContext, Instance], size int) *Executor[TxContext, R] {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &Executor[TxContext, R]{
+		automata: automata,
+		runInTx:  runInTx,
+		slots:    make(chan struct{}, size),
+		closing:  make(chan struct{}),
+		running:  map[*Handle[R]]struct{}{},
+	}
+}
+
+// ExecuteAsync starts the execution of the given Instance in the background, see
+// Automata.Execute, and returns a Handle to the execution. If all goroutines of the
+// Executor are busy, ExecuteAsync waits for a free one or until the given context is done.
+// ExecuteAsync returns ErrExecutorClosed right away, if the Executor is closed.
+//
+// The execution keeps the values of the given context, but is not canceled with it.
+// Use Handle.Cancel to cancel the execution.
+func (e *Executor[TxContext, R]) ExecuteAsync(ctx context.Context, instance Instance) (*Handle[R], error) {
+	select {
+	case <-e.closing:
+		return nil, ErrExecutorClosed
+
+	case e.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	execCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	handle := &Handle[R]{
+		instance: instance,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		cancel()
+		<-e.slots
+		return nil, ErrExecutorClosed
+	}
+
+	e.running[handle] = struct{}{}
+	e.wg.Add(1)
+
+	go e.execute(withProgress(execCtx, handle.progress), handle)
+
+	return handle, nil
+}
+
+func (e *Executor[TxContext, R]) execute(ctx context.Context, handle *Handle[R]) {
+	defer e.wg.Done()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.running, handle)
+		e.mu.Unlock()
+
+		<-e.slots
+	}()
+
+	defer handle.cancel()
+
+	result, err := e.automata.Execute(ctx, e.runInTx, handle.Instance())
+	handle.finish(result, err)
+}
+
+// Close stops accepting new executions and waits for all running executions to finish.
+// If the given context is done first, the remaining executions are canceled. Close waits
+// for the canceled executions to return and then returns the error of the context.
+func (e *Executor[TxContext, R]) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.closing)
+	}
+	e.mu.Unlock()
+
+	drained := make(chan struct{})
+
+	go func() {
+		e.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+
+	case <-ctx.Done():
+		e.mu.Lock()
+		for handle := range e.running {
+			handle.Cancel()
+		}
+		e.mu.Unlock()
+
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// Handle is a handle to an asynchronous execution of an Instance, see Executor.ExecuteAsync.
+type Handle[R any] struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	instance Instance
+	result   R
+	err      error
+}
+
+// Await waits until the execution finished and returns the result of the execution,
+// see Automata.Execute. If the given context is done first, its error is returned.
+func (h *Handle[R]) Await(ctx context.Context) (R, error) {
+	select {
+	case <-h.done:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		return h.result, h.err
+
+	case <-ctx.Done():
+		var nilT R
+		return nilT, ctx.Err()
+	}
+}
+
+// Cancel cancels the context of the execution. The execution stops after the current
+// step, the Instance keeps the State of the last applied transition.
+func (h *Handle[R]) Cancel() {
+	h.cancel()
+}
+
+// Done returns a channel that is closed when the execution finished.
+func (h *Handle[R]) Done() <-chan struct{} {
+	return h.done
+}
+
+// Instance returns the latest known Instance of the execution. It is
+// updated after every transition.
+func (h *Handle[R]) Instance() Instance {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.instance
+}
+
+func (h *Handle[R]) progress(instance Instance) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.instance = instance
+}
+
+func (h *Handle[R]) finish(result R, err error) {
+	h.mu.Lock()
+	h.result, h.err = result, err
+	h.mu.Unlock()
+
+	close(h.done)
+}
+
+type progressKey struct{}
+
+// withProgress attaches a function to the context that is called
+// with the new Instance after every transition.
+func withProgress(ctx context.Context, fn func(instance Instance)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress reports the new instance to the function attached using withProgress.
+func reportProgress(ctx context.Context, instance Instance) {
+	if fn, ok := ctx.Value(progressKey{}).(func(Instance)); ok {
+		fn(instance)
+	}
+}
@@ -0,0 +1,197 @@
+package pee
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Executor", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateC struct {
+		State `name:"C"`
+	}
+
+	var a *Automata[context.Context, string]
+	var release chan struct{}
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StateB) (*StateTransition[context.Context], error) {
+			select {
+			case <-release:
+				return Transition[context.Context](StateC{}).AsTuple()
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateC) (string, error) {
+			return "done", nil
+		})
+	})
+
+	It("executes an instance in the background", func() {
+		executor := NewExecutor(a, DummyRunInTx, 2)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		handle, err := executor.ExecuteAsync(ctx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		// the current state can be polled while the execution is blocked
+		Eventually(func() string { return handle.Instance().StateName }).Should(Equal("B"))
+		Consistently(handle.Done()).ShouldNot(BeClosed())
+
+		close(release)
+
+		result, err := handle.Await(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+		Expect(handle.Instance().StateName).To(Equal("C"))
+
+		Expect(executor.Close(ctx)).To(Succeed())
+	})
+
+	It("cancels an execution", func() {
+		executor := NewExecutor(a, DummyRunInTx, 1)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		handle, err := executor.ExecuteAsync(ctx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() string { return handle.Instance().StateName }).Should(Equal("B"))
+
+		handle.Cancel()
+
+		_, err = handle.Await(ctx)
+		Expect(err).To(MatchError(context.Canceled))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("B"))
+	})
+
+	It("cancels an execution between steps if the handlers ignore the context", func() {
+		type StateX struct {
+			State `name:"X"`
+		}
+
+		entered := make(chan struct{})
+
+		AddState(a, func(ctx context.Context, state StateX) (*StateTransition[context.Context], error) {
+			close(entered)
+			<-release
+			return Transition[context.Context](StateA{}).AsTuple()
+		})
+
+		executor := NewExecutor(a, DummyRunInTx, 1)
+
+		instance, err := a.Start(ctx, StateX{})
+		Expect(err).ToNot(HaveOccurred())
+
+		handle, err := executor.ExecuteAsync(ctx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(entered).Should(BeClosed())
+
+		handle.Cancel()
+		close(release)
+
+		_, err = handle.Await(ctx)
+		Expect(err).To(MatchError(context.Canceled))
+
+		// the current step is finished, but no further step is applied
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("A"))
+	})
+
+	It("bounds the number of concurrent executions", func() {
+		executor := NewExecutor(a, DummyRunInTx, 1)
+
+		first, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = executor.ExecuteAsync(ctx, first)
+		Expect(err).ToNot(HaveOccurred())
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err = executor.ExecuteAsync(timeoutCtx, second)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+
+		close(release)
+		Expect(executor.Close(ctx)).To(Succeed())
+	})
+
+	It("does not wait for a free slot once closed", func() {
+		executor := NewExecutor(a, DummyRunInTx, 1)
+
+		first, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = executor.ExecuteAsync(ctx, first)
+		Expect(err).ToNot(HaveOccurred())
+
+		closed := make(chan error)
+		go func() { closed <- executor.Close(ctx) }()
+
+		Eventually(executor.closing).Should(BeClosed())
+
+		// all slots are taken, but the executor is closed
+		_, err = executor.ExecuteAsync(ctx, second)
+		Expect(err).To(MatchError(ErrExecutorClosed))
+
+		close(release)
+		Eventually(closed).Should(Receive(Succeed()))
+	})
+
+	It("drains running executions on close", func() {
+		executor := NewExecutor(a, DummyRunInTx, 2)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		handle, err := executor.ExecuteAsync(ctx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		closeCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		// the blocked execution is canceled after the close timeout
+		Expect(executor.Close(closeCtx)).To(MatchError(context.DeadlineExceeded))
+		Expect(handle.Done()).To(BeClosed())
+
+		_, err = executor.ExecuteAsync(ctx, instance)
+		Expect(err).To(MatchError(ErrExecutorClosed))
+	})
+})
@@ -2,6 +2,7 @@ package pee
 
 import (
 	"context"
+	"errors"
 	"github.com/onsi/ginkgo/v2/types"
 	_ "modernc.org/sqlite"
 	"strings"
@@ -69,6 +70,55 @@ var _ = Describe("Automata", func() {
 			}
 		})
 
+		It("exposes labels, state name and timestamps on the instance", func() {
+			instance, err := a.Start(ctx, StateA{Input: "my input value"}, WithLabel("tenant", "acme"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.StateName).To(Equal("A"))
+			Expect(instance.CreatedAt).ToNot(BeZero())
+
+			_, err = a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err).ToNot(HaveOccurred())
+
+			instance, err = a.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.StateName).To(Equal("B"))
+			Expect(instance.Labels).To(Equal(map[string]string{"tenant": "acme"}))
+			Expect(instance.UpdatedAt).To(BeTemporally(">=", instance.CreatedAt))
+		})
+
+		It("uses client generated ids if configured", func() {
+			a.WithIdGenerator(ULIDGenerator)
+
+			instance, err := a.Start(ctx, StateA{Input: "my input value"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Id).To(HaveLen(26))
+
+			instance, err = a.Start(ctx, StateA{Input: "my input value"}, WithId("my-id"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Id).To(Equal(InstanceId("my-id")))
+
+			res, err := a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res).To(Equal("my output value (transformed)"))
+		})
+
+		It("lists instances by state", func() {
+			first, err := a.Start(ctx, StateA{Input: "first input"})
+			Expect(err).ToNot(HaveOccurred())
+
+			second, err := a.Start(ctx, StateA{Input: "second input"})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = a.Execute(ctx, DummyRunInTx, first)
+			Expect(err).ToNot(HaveOccurred())
+
+			instances, err := a.List(ctx, ListQuery{StateNames: []string{"A"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].Id).To(Equal(second.Id))
+			Expect(instances[0].State).To(Equal(StateA{Input: "second input"}))
+		})
+
 		It("correctly handles concurrent updates", func() {
 			instance, err := a.Start(ctx, StateA{Input: "my input value"})
 			Expect(err).ToNot(HaveOccurred())
@@ -77,7 +127,13 @@ var _ = Describe("Automata", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			_, err = a.Execute(ctx, DummyRunInTx, instance)
-			Expect(err).To(And(HaveOccurred(), Equal(ErrOptimisticLocking)))
+			Expect(err).To(MatchError(ErrOptimisticLocking))
+
+			var storeErr *StoreError
+			Expect(errors.As(err, &storeErr)).To(BeTrue())
+			Expect(storeErr.InstanceId).To(Equal(instance.Id))
+			Expect(storeErr.StateName).To(Equal("A"))
+			Expect(IsRetryable(err)).To(BeTrue())
 		})
 	})
 
@@ -0,0 +1,92 @@
+package pee
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultAwaitInterval is the default time between two polls of Automata.Await.
+const DefaultAwaitInterval = time.Second
+
+// WithNotifier configures a Notifier to get notified about changed instances, e.g. using
+// Postgres LISTEN/NOTIFY. Waiting for instances, e.g. using Await, is woken up by notifications
+// and falls back to polling the Store.
+func (a *Automata[TxContext, R]) WithNotifier(notifier Notifier) *Automata[TxContext, R] {
+	a.notifier = notifier
+	return a
+}
+
+// WithAwaitInterval configures the time between two polls of the Store in Await.
+// Defaults to DefaultAwaitInterval.
+func (a *Automata[TxContext, R]) WithAwaitInterval(interval time.Duration) *Automata[TxContext, R] {
+	a.awaitInterval = interval
+	return a
+}
+
+// Await waits until the Instance with the given id reached a final State, e.g. when the
+// Instance is executed by a Runner in another process. It returns the persisted result of the
+// Instance, see WithResultPersistence, or calls the Transform of the final State. The Instance
+// is not executed by Await. The Instance is loaded in a new transaction created by runInTx
+// whenever the Notifier signals a change of the Instance, or after the await interval passed.
+//
+// Await fails if the Instance is dead lettered or the given context is done.
+func (a *Automata[TxContext, R]) Await(ctx context.Context, runInTx RunInTx[TxContext, Instance], id InstanceId) (R, error) {
+	var nilT R
+
+	// subscribe first to not miss any change after loading the instance
+	var changes <-chan InstanceId
+	if a.notifier != nil {
+		var unsubscribe func()
+		changes, unsubscribe = a.notifier.Subscribe()
+		defer unsubscribe()
+	}
+
+	interval := a.awaitInterval
+	if interval <= 0 {
+		interval = DefaultAwaitInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		instance, err := runInTx(ctx, func(tx TxContext) (Instance, error) {
+			return a.Load(tx, id)
+		})
+
+		if err != nil {
+			return nilT, err
+		}
+
+		if final, ok := a.finalStates[NameOf(instance.State)]; ok {
+			// the instance is not executed again, only its result is computed
+			return a.finalResult(ctx, runInTx, &instance, final)
+		}
+
+		if instance.DeadLetter {
+			return nilT, &DeadLetterError{ErrorContext: errorContextOf(instance)}
+		}
+
+		if err := waitForChange(ctx, id, changes, ticker.C); err != nil {
+			return nilT, err
+		}
+	}
+}
+
+// waitForChange waits for a change of the instance with the given id or the next tick.
+func waitForChange(ctx context.Context, id InstanceId, changes <-chan InstanceId, tick <-chan time.Time) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-tick:
+			return nil
+
+		case changed := <-changes:
+			if changed == id {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Await", func() {
+	type StateA struct {
+		State `name:"A"`
+		Fail  bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+		Value string
+	}
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore()).WithDeadLetter(MaxAttempts(1))
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			if state.Fail {
+				return nil, errors.New("failed")
+			}
+
+			return Transition[context.Context](StateB{Value: "result"}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return state.Value, nil
+		})
+	})
+
+	// await runs Await in the background
+	await := func(id InstanceId) chan error {
+		done := make(chan error, 1)
+
+		go func() {
+			result, err := a.Await(ctx, DummyRunInTx, id)
+			if err == nil && result != "result" {
+				err = errors.New("unexpected result: " + result)
+			}
+
+			done <- err
+		}()
+
+		return done
+	}
+
+	It("returns the result of a finished instance", func() {
+		instance, err := a.Start(ctx, StateB{Value: "result"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(await(instance.Id)).Should(Receive(BeNil()))
+	})
+
+	It("does not execute a finished instance", func() {
+		var steps []StepKind
+		a.Use(func(ctx context.Context, step Step, next func(ctx context.Context) error) error {
+			steps = append(steps, step.Kind)
+			return next(ctx)
+		})
+
+		instance, err := a.Start(ctx, StateB{Value: "result"})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Await(ctx, DummyRunInTx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("result"))
+
+		Expect(steps).To(Equal([]StepKind{StepFinal}))
+	})
+
+	It("polls until the instance is finished", func() {
+		a.WithAwaitInterval(5 * time.Millisecond)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		done := await(instance.Id)
+		Consistently(done, 20*time.Millisecond).ShouldNot(Receive())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("is woken up by the notifier", func() {
+		notifier := NewBroadcaster()
+
+		a.WithAwaitInterval(time.Hour).WithNotifier(notifier)
+
+		a.WithHooks(Hooks{
+			AfterTransition: func(ctx context.Context, from, to Instance) {
+				notifier.Publish(to.Id)
+			},
+		})
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		done := await(instance.Id)
+		Consistently(done, 20*time.Millisecond).ShouldNot(Receive())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("fails for dead lettered instances", func() {
+		instance, err := a.Start(ctx, StateA{Fail: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ErrDeadLetter))
+
+		Eventually(await(instance.Id)).Should(Receive(MatchError(ErrDeadLetter)))
+	})
+})
@@ -0,0 +1,274 @@
+package pee
+
+import (
+	"context"
+	"errors"
+)
+
+// StartRequest describes a new Instance started by Automata.StartMany.
+type StartRequest struct {
+	// State is the initial State of the new Instance.
+	State State
+
+	// Options configure the new Instance, e.g. WithId or WithLabel.
+	Options []StartOption
+}
+
+// StartMany starts a new Instance for every request and returns the instances in the
+// order of the requests. Either all instances are started or none. If the Store
+// implements BatchStore, all instances are created with a single call to CreateMany,
+// otherwise every instance is created on its own within the given transaction.
+func (a *Automata[TxContext, _]) StartMany(ctx TxContext, requests []StartRequest) ([]Instance, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	store, ok := a.store.(BatchStore[TxContext])
+	if !ok {
+		instances := make([]Instance, 0, len(requests))
+
+		for _, request := range requests {
+			instance, err := a.Start(ctx, request.State, request.Options...)
+			if err != nil {
+				return nil, err
+			}
+
+			instances = append(instances, instance)
+		}
+
+		return instances, nil
+	}
+
+	serializedInstances := make([]SerializedInstance, 0, len(requests))
+
+	for _, request := range requests {
+		instance, err := a.newSerializedInstance(ctx, request.State, request.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		serializedInstances = append(serializedInstances, instance)
+	}
+
+	created, err := store.CreateMany(ctx, serializedInstances)
+	if err != nil {
+		return nil, &StoreError{Op: "create many", Err: err}
+	}
+
+	if len(created) != len(requests) {
+		return nil, &StoreError{Op: "create many", Err: makeErr("created %d of %d instances", len(created), len(requests))}
+	}
+
+	instances := make([]Instance, 0, len(created))
+	for idx, instance := range created {
+		instances = append(instances, newInstance(instance, requests[idx].State))
+	}
+
+	return instances, nil
+}
+
+// StepResult is the outcome of a single Instance of Automata.StepMany.
+type StepResult struct {
+	// Instance is the Instance after the step. It is unchanged, if the step failed.
+	Instance Instance
+
+	// Err is the error of the step, e.g. ErrFinalState if the Instance was already in a final State.
+	Err error
+}
+
+// StepMany applies exactly one transition to each of the given instances, like Step does for
+// a single Instance, and returns the results in the order of the instances. The handlers of
+// all instances are run first, then all transitions are applied in a single transaction created
+// by runInTx. This saves a transaction per Instance.
+//
+// If the shared transaction fails, e.g. because an Instance was changed concurrently, it is
+// rolled back and the transitions are applied again, each in its own transaction, so that only
+// the failing instances are affected. The handlers are not run again, but the actions of the
+// transitions are. Actions of transitions used with StepMany must therefore be idempotent.
+//
+// The handler of every stepped Instance runs within a StepExecute of its own. The shared
+// transaction is not part of any StepExecute, its transitions are reported as StepTransition
+// steps. If the shared transaction fails, every Instance applies its transition within another
+// StepExecute. Dead lettered instances are reported to the OnError hook only. Failures are
+// reported through the logger, hooks and middlewares of the Automata.
+func (a *Automata[TxContext, R]) StepMany(ctx context.Context, runInTx RunInTx[TxContext, Instance], instances []Instance) []StepResult {
+	return a.stepMany(ctx, runInTx, instances, nil)
+}
+
+// stepMany steps the given instances like StepMany. The optional visit function is called
+// for every instance within its StepExecute, before its handler runs. An error of visit
+// fails the step of the instance.
+func (a *Automata[TxContext, R]) stepMany(ctx context.Context, runInTx RunInTx[TxContext, Instance], instances []Instance, visit func(instance Instance) error) []StepResult {
+	results := make([]StepResult, len(instances))
+
+	// the transitions of the instances with a successful handler
+	var steps []*batchStep[TxContext]
+
+	for idx, instance := range instances {
+		results[idx].Instance = instance
+
+		switch {
+		case a.isFinal(instance):
+			results[idx].Err = ErrFinalState
+
+		case instance.DeadLetter:
+			// dead lettered instances need to be re-driven first
+			err := &DeadLetterError{ErrorContext: errorContextOf(instance)}
+			a.hooks.onError(ctx, instance, err)
+			results[idx].Err = err
+
+		default:
+			step := &batchStep[TxContext]{idx: idx}
+
+			results[idx].Err = a.aroundExecute(ctx, &instance, func(ctx context.Context) error {
+				return a.prepareStep(ctx, runInTx, instance, visit, step)
+			})
+
+			if results[idx].Err == nil {
+				steps = append(steps, step)
+			}
+		}
+	}
+
+	if len(steps) == 0 {
+		return results
+	}
+
+	if err := a.applyBatch(ctx, runInTx, steps); err != nil {
+		// apply the transitions again, each in its own transaction
+		for _, step := range steps {
+			instance := results[step.idx].Instance
+
+			results[step.idx].Err = a.aroundExecute(ctx, &instance, func(ctx context.Context) error {
+				step.transition.reset()
+				return a.commitTransition(ctx, runInTx, &instance, step.source, step.transition)
+			})
+
+			results[step.idx].Instance = instance
+		}
+
+		return results
+	}
+
+	for _, step := range steps {
+		a.hooks.afterTransition(ctx, results[step.idx].Instance, step.result)
+		reportProgress(ctx, step.result)
+
+		results[step.idx].Instance = step.result
+	}
+
+	return results
+}
+
+// batchStep is the transition of a single instance of StepMany.
+type batchStep[TxContext context.Context] struct {
+	// index of the instance in the batch
+	idx int
+
+	source     Instance
+	transition *StateTransition[TxContext]
+
+	// the new instance after the shared transaction
+	result Instance
+}
+
+// prepareStep runs the handler of the instance and remembers its transition in the given step.
+func (a *Automata[TxContext, R]) prepareStep(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance, visit func(instance Instance) error, step *batchStep[TxContext]) error {
+	a.hooks.beforeStep(ctx, instance)
+
+	if visit != nil {
+		if err := visit(instance); err != nil {
+			return err
+		}
+	}
+
+	source, transition, err := a.nextTransition(ctx, runInTx, instance)
+	if err != nil {
+		return err
+	}
+
+	step.source, step.transition = source, transition
+
+	return nil
+}
+
+// applyBatch applies the transitions of all given steps in a single transaction.
+func (a *Automata[TxContext, R]) applyBatch(ctx context.Context, runInTx RunInTx[TxContext, Instance], steps []*batchStep[TxContext]) error {
+	_, err := runInTx(ctx, func(tx TxContext) (Instance, error) {
+		for _, step := range steps {
+			// the transaction might be retried by runInTx
+			step.transition.reset()
+
+			result, err := a.applyTransitionIn(tx, step.source, step.transition)
+			if err != nil {
+				return Instance{}, err
+			}
+
+			step.result = result
+		}
+
+		return Instance{}, nil
+	})
+
+	return err
+}
+
+// executeBatched runs the given instances until they are final, failed or limited, applying the
+// next transition of all instances together with StepMany. Final instances are executed once
+// more, to run their final transform. Returns the number of finished or failed instances.
+func (r *Runner[TxContext, R]) executeBatched(ctx context.Context, instances []Instance) int {
+	var executed int
+
+	// states that reached their limit during this poll
+	limited := map[string]bool{}
+
+	guards := map[InstanceId]*stepGuard{}
+
+	visit := func(instance Instance) error {
+		guard, ok := guards[instance.Id]
+		if !ok {
+			guard = newStepGuard(r.automata.maxSteps)
+			guards[instance.Id] = guard
+		}
+
+		return guard.visit(instance)
+	}
+
+	for len(instances) > 0 && ctx.Err() == nil {
+		var batch []Instance
+
+		for _, instance := range instances {
+			switch {
+			case limited[instance.StateName]:
+				continue
+
+			case r.automata.isFinal(instance):
+				// run the final transform and persist the result
+				_, _ = r.automata.Execute(ctx, r.runInTx, instance)
+				executed++
+
+			default:
+				batch = append(batch, instance)
+			}
+		}
+
+		instances = nil
+
+		for _, result := range r.automata.stepMany(ctx, r.runInTx, batch, visit) {
+			var limitErr *LimitError
+
+			switch {
+			case errors.As(result.Err, &limitErr):
+				limited[limitErr.StateName] = true
+
+			case result.Err != nil:
+				executed++
+
+			default:
+				instances = append(instances, result.Instance)
+			}
+		}
+	}
+
+	return executed
+}
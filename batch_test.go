@@ -0,0 +1,318 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batches", func() {
+	type StateA struct {
+		State `name:"A"`
+		Name  string
+	}
+
+	type StateB struct {
+		State `name:"B"`
+		Name  string
+	}
+
+	type StateBroken struct {
+		State `name:"Broken"`
+	}
+
+	type StateDone struct {
+		State `name:"Done"`
+		Name  string
+	}
+
+	var store Store[context.Context]
+	var a *Automata[context.Context, string]
+
+	// counts the transactions started by runInTx
+	var transactions int
+
+	// counts the handler calls by the name of the state
+	var handled map[string]int
+
+	// the instances of all StepExecute steps
+	var executions []InstanceId
+
+	// the number of open StepExecute steps, and whether they were ever nested
+	var executing int
+	var nested bool
+
+	// the number of transitions applied outside a StepExecute
+	var sharedTransitions int
+
+	runInTx := func(ctx context.Context, fn func(ctx context.Context) (Instance, error)) (Instance, error) {
+		transactions++
+		return DummyRunInTx(ctx, fn)
+	}
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		transactions = 0
+		handled = map[string]int{}
+		executions = nil
+		executing, nested, sharedTransitions = 0, false, 0
+
+		store = NewMemoryStore()
+		a = New[string](store)
+
+		a.Use(func(ctx context.Context, step Step, next func(ctx context.Context) error) error {
+			switch {
+			case step.Kind == StepExecute:
+				executions = append(executions, step.Instance.Id)
+
+				nested = nested || executing > 0
+				executing++
+				defer func() { executing-- }()
+
+			case step.Kind == StepTransition && executing == 0:
+				sharedTransitions++
+			}
+
+			return next(ctx)
+		})
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			handled[state.Name]++
+			return Transition[context.Context](StateB{Name: state.Name}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StateB) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateDone{Name: state.Name}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StateBroken) (*StateTransition[context.Context], error) {
+			return TransitionInTx(func(ctx context.Context) (State, error) {
+				return nil, errors.New("broken")
+			}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateDone) (string, error) {
+			return "done " + state.Name, nil
+		})
+	})
+
+	names := func(instances []Instance) []string {
+		var names []string
+		for _, instance := range instances {
+			names = append(names, instance.State.(StateA).Name)
+		}
+
+		return names
+	}
+
+	requests := []StartRequest{
+		{State: StateA{Name: "first"}, Options: []StartOption{WithLabel("tenant", "a")}},
+		{State: StateA{Name: "second"}, Options: []StartOption{WithId("custom")}},
+		{State: StateA{Name: "third"}},
+	}
+
+	It("starts many instances in the order of the requests", func() {
+		instances, err := a.StartMany(ctx, requests)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(instances)).To(Equal([]string{"first", "second", "third"}))
+
+		Expect(instances[0].Labels).To(HaveKeyWithValue("tenant", "a"))
+		Expect(instances[1].Id).To(Equal(InstanceId("custom")))
+
+		for _, instance := range instances {
+			loaded, err := a.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.State).To(Equal(instance.State))
+		}
+	})
+
+	It("lists instances started together in the order of the requests", func() {
+		var requests []StartRequest
+		var expected []string
+
+		for idx := 0; idx < 50; idx++ {
+			name := fmt.Sprint(idx)
+			tenant := WithLabel("tenant", fmt.Sprint(idx%2))
+
+			requests = append(requests, StartRequest{State: StateA{Name: name}, Options: []StartOption{tenant}})
+			expected = append(expected, name)
+		}
+
+		_, err := a.StartMany(ctx, requests)
+		Expect(err).ToNot(HaveOccurred())
+
+		for attempt := 0; attempt < 5; attempt++ {
+			instances, err := a.List(ctx, ListQuery{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names(instances)).To(Equal(expected))
+
+			instances, err = a.List(ctx, ListQuery{FairnessLabel: "tenant", Limit: 4})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names(instances)).To(Equal([]string{"0", "1", "2", "3"}))
+		}
+	})
+
+	It("starts none of the instances if one can not be created", func() {
+		_, err := a.Start(ctx, StateA{Name: "existing"}, WithId("custom"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.StartMany(ctx, requests)
+		Expect(err).To(BeAssignableToTypeOf(&StoreError{}))
+
+		instances, err := a.List(ctx, ListQuery{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(instances)).To(Equal([]string{"existing"}))
+	})
+
+	It("starts many instances one by one if the store does not support batches", func() {
+		a := New[string](noScheduleStore{store})
+
+		AddFinalState(a, func(ctx context.Context, state StateA) (string, error) {
+			return state.Name, nil
+		})
+
+		instances, err := a.StartMany(ctx, requests)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(instances)).To(Equal([]string{"first", "second", "third"}))
+	})
+
+	It("applies the next transition of many instances in a single transaction", func() {
+		instances, err := a.StartMany(ctx, requests)
+		Expect(err).ToNot(HaveOccurred())
+
+		done, err := a.Start(ctx, StateDone{})
+		Expect(err).ToNot(HaveOccurred())
+
+		results := a.StepMany(ctx, runInTx, append(instances, done))
+		Expect(results).To(HaveLen(4))
+		Expect(transactions).To(Equal(1))
+
+		for idx, result := range results[:3] {
+			Expect(result.Err).ToNot(HaveOccurred())
+			Expect(result.Instance.Id).To(Equal(instances[idx].Id))
+			Expect(result.Instance.Version).To(Equal(2))
+			Expect(result.Instance.State).To(Equal(StateB{Name: names(instances)[idx]}))
+		}
+
+		Expect(results[3].Err).To(MatchError(ErrFinalState))
+		Expect(results[3].Instance).To(Equal(done))
+
+		// every handler runs in its own execution, the shared transaction in none
+		Expect(executions).To(ConsistOf(instances[0].Id, instances[1].Id, instances[2].Id))
+		Expect(nested).To(BeFalse())
+		Expect(sharedTransitions).To(Equal(3))
+	})
+
+	It("reports dead lettered instances to the hooks only", func() {
+		instance, err := a.Start(ctx, StateA{Name: "dead"})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance.DeadLetter = true
+
+		var errs []error
+		a.WithHooks(Hooks{
+			OnError: func(ctx context.Context, instance Instance, err error) {
+				errs = append(errs, err)
+			},
+		})
+
+		results := a.StepMany(ctx, runInTx, []Instance{instance})
+		Expect(results[0].Err).To(BeAssignableToTypeOf(&DeadLetterError{}))
+
+		Expect(errs).To(HaveLen(1))
+		Expect(executions).To(BeEmpty())
+		Expect(handled).To(BeEmpty())
+		Expect(transactions).To(BeZero())
+	})
+
+	It("applies every transition on its own if the transaction fails", func() {
+		// the memory store can not roll back, so the broken instance fails first
+		broken, err := a.Start(ctx, StateBroken{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instances, err := a.StartMany(ctx, requests)
+		Expect(err).ToNot(HaveOccurred())
+
+		var failed []InstanceId
+		a.WithHooks(Hooks{
+			OnError: func(ctx context.Context, instance Instance, err error) {
+				failed = append(failed, instance.Id)
+			},
+		})
+
+		results := a.StepMany(ctx, runInTx, append([]Instance{broken}, instances...))
+		// the shared transaction, one per instance and one recording the failed attempt
+		Expect(transactions).To(Equal(1 + 4 + 1))
+
+		var transitionErr *TransitionError
+		Expect(errors.As(results[0].Err, &transitionErr)).To(BeTrue())
+		Expect(results[0].Instance.Version).To(Equal(1))
+
+		for _, result := range results[1:] {
+			Expect(result.Err).ToNot(HaveOccurred())
+			Expect(result.Instance.Version).To(Equal(2))
+		}
+
+		Expect(failed).To(Equal([]InstanceId{broken.Id}))
+
+		// the handlers are not run again for the transactions of the single instances
+		Expect(handled).To(Equal(map[string]int{"first": 1, "second": 1, "third": 1}))
+		// one execution for the handler and one for the transition of every instance
+		ids := []InstanceId{broken.Id, instances[0].Id, instances[1].Id, instances[2].Id}
+		Expect(executions).To(Equal(append(ids, ids...)))
+		Expect(nested).To(BeFalse())
+	})
+
+	It("executes instances with batched steps in a runner", func() {
+		_, err := a.StartMany(ctx, requests)
+		Expect(err).ToNot(HaveOccurred())
+
+		runner := NewRunner(a, runInTx, RunnerConfig{BatchSteps: true})
+
+		executed, err := runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(executed).To(Equal(3))
+
+		instances, err := a.List(ctx, ListQuery{})
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, instance := range instances {
+			Expect(instance.StateName).To(Equal("Done"))
+		}
+	})
+
+	It("stops instances caught in a cycle with batched steps", func() {
+		type StatePing struct {
+			State `name:"Ping"`
+		}
+
+		AddState(a, func(ctx context.Context, state StatePing) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StatePing{}).AsTuple()
+		})
+
+		a.WithMaxSteps(3)
+
+		_, err := a.Start(ctx, StatePing{})
+		Expect(err).ToNot(HaveOccurred())
+
+		var errs []error
+		a.WithHooks(Hooks{
+			OnError: func(ctx context.Context, instance Instance, err error) {
+				errs = append(errs, err)
+			},
+		})
+
+		runner := NewRunner(a, runInTx, RunnerConfig{BatchSteps: true})
+
+		executed, err := runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(executed).To(Equal(1))
+
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0]).To(BeAssignableToTypeOf(&MaxStepsError{}))
+	})
+})
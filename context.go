@@ -0,0 +1,22 @@
+package pee
+
+import (
+	"context"
+)
+
+type instanceKey struct{}
+
+// InstanceFromContext returns the Instance that is currently executed. The Instance
+// is available in the context passed to a Handler, a Transform, a Middleware and
+// the Action of a StateTransition.
+//
+// Within an Action, the Instance is only available if the TxContext is derived
+// from the context passed to RunInTx or supports a `WithContext` method like ql.TxContext.
+func InstanceFromContext(ctx context.Context) (Instance, bool) {
+	instance, ok := ctx.Value(instanceKey{}).(Instance)
+	return instance, ok
+}
+
+func withInstance(ctx context.Context, instance Instance) context.Context {
+	return context.WithValue(ctx, instanceKey{}, instance)
+}
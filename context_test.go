@@ -0,0 +1,104 @@
+package pee
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Instance in context", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	var a *Automata[context.Context, Instance]
+	var failures int
+	var seen []Instance
+
+	ctx := context.Background()
+
+	record := func(ctx context.Context) {
+		instance, ok := InstanceFromContext(ctx)
+		Expect(ok).To(BeTrue())
+
+		seen = append(seen, instance)
+	}
+
+	BeforeEach(func() {
+		failures = 0
+		seen = nil
+
+		a = New[Instance](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			record(ctx)
+
+			if failures > 0 {
+				failures--
+				return nil, errors.New("failed")
+			}
+
+			return a.NewTransition(StateB{}).
+				WithInfallibleAction(func(ctx context.Context) { record(ctx) }).
+				AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (Instance, error) {
+			record(ctx)
+
+			instance, _ := InstanceFromContext(ctx)
+			return instance, nil
+		})
+	})
+
+	It("provides the instance to handlers, actions and transforms", func() {
+		instance, err := a.Start(ctx, StateA{}, WithLabel("tenant", "acme"))
+		Expect(err).ToNot(HaveOccurred())
+
+		final, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(final.Id).To(Equal(instance.Id))
+		Expect(final.Version).To(Equal(2))
+		Expect(final.StateName).To(Equal("B"))
+		Expect(final.Labels).To(Equal(map[string]string{"tenant": "acme"}))
+
+		Expect(seen).To(HaveLen(3))
+		Expect(seen[0].StateName).To(Equal("A"))
+		Expect(seen[1].StateName).To(Equal("A"))
+		Expect(seen[2].StateName).To(Equal("B"))
+	})
+
+	It("counts failed attempts", func() {
+		failures = 2
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		for attempt := 1; attempt <= 3; attempt++ {
+			instance, err = a.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err == nil).To(Equal(attempt == 3))
+
+			// the handler sees the number of the current attempt
+			Expect(seen[attempt-1].Attempt()).To(Equal(attempt))
+		}
+
+		// attempts are reset after a transition
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.Attempts).To(Equal(0))
+	})
+
+	It("is not available outside of an execution", func() {
+		_, ok := InstanceFromContext(ctx)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,105 @@
+package pee
+
+import (
+	"context"
+	"errors"
+)
+
+// DeadLetterPolicy decides if an Instance is dead lettered after a failed attempt.
+// It receives the number of failed attempts in the current state, including the
+// failed attempt, and the error of the attempt.
+type DeadLetterPolicy func(attempts int, err error) bool
+
+// MaxAttempts returns a DeadLetterPolicy that dead letters an Instance after the given
+// number of failed attempts, or immediately if the error is not retryable, see IsRetryable.
+// Canceled contexts never dead letter an Instance.
+func MaxAttempts(maxAttempts int) DeadLetterPolicy {
+	return func(attempts int, err error) bool {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+
+		return attempts >= maxAttempts || !IsRetryable(err)
+	}
+}
+
+// WithDeadLetter configures the policy to dead letter instances that fail permanently.
+// A dead lettered Instance is marked in the Store together with the last error and is not
+// executed anymore until it is re-driven using Redrive. The Store must implement FailureStore.
+func (a *Automata[TxContext, R]) WithDeadLetter(policy DeadLetterPolicy) *Automata[TxContext, R] {
+	a.deadLetterPolicy = policy
+	return a
+}
+
+// ListDeadLetters returns the dead lettered instances of this Automata, oldest instances first.
+// The Store must implement ListStore, otherwise ErrNotSupported is returned.
+func (a *Automata[TxContext, _]) ListDeadLetters(ctx TxContext, limit int) ([]Instance, error) {
+	return a.List(ctx, ListQuery{DeadLetter: OnlyDeadLetters, Limit: limit})
+}
+
+// Redrive clears the dead letter flag, the failed attempts and the last error of the
+// Instance with the given id and returns the updated Instance. The Instance can then
+// be executed again, continuing in its current state.
+// The Store must implement FailureStore, otherwise ErrNotSupported is returned.
+func (a *Automata[TxContext, _]) Redrive(ctx TxContext, id InstanceId) (Instance, error) {
+	store, ok := a.store.(FailureStore[TxContext])
+	if !ok {
+		return Instance{}, ErrNotSupported
+	}
+
+	instance, err := a.Load(ctx, id)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	if err := store.ClearFailure(ctx, instance.Id, instance.Version); err != nil {
+		return Instance{}, &StoreError{ErrorContext: errorContextOf(instance), Op: "clear failure", Err: err}
+	}
+
+	instance.Attempts = 0
+	instance.LastError = ""
+	instance.LastErrorStack = ""
+	instance.DeadLetter = false
+
+	return instance, nil
+}
+
+// recordFailure records a failed attempt of the instance, if the Store implements FailureStore.
+// If the DeadLetterPolicy decides to dead letter the instance, the error is returned
+// as a DeadLetterError. The error is joined with the error of the store, if recording failed.
+func (a *Automata[TxContext, R]) recordFailure(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance, err error) error {
+	store, ok := a.store.(FailureStore[TxContext])
+	if !ok || errors.Is(err, ErrOptimisticLocking) {
+		return err
+	}
+
+	failure := Failure{Error: err.Error()}
+
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		failure.Stack = string(panicErr.Stack)
+	}
+
+	if a.deadLetterPolicy != nil && a.deadLetterPolicy(instance.Attempt(), err) {
+		failure.DeadLetter = true
+	}
+
+	_, recordErr := runInTx(ctx, func(tx TxContext) (Instance, error) {
+		return Instance{}, store.RecordFailure(tx, instance.Id, instance.Version, failure)
+	})
+
+	// the instance was updated concurrently, no need to track this attempt
+	if errors.Is(recordErr, ErrOptimisticLocking) {
+		return err
+	}
+
+	if recordErr != nil {
+		return errors.Join(err, &StoreError{ErrorContext: errorContextOf(instance), Op: "record failure", Err: recordErr})
+	}
+
+	if failure.DeadLetter {
+		return &DeadLetterError{ErrorContext: errorContextOf(instance), Err: err}
+	}
+
+	return err
+}
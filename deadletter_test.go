@@ -0,0 +1,130 @@
+package pee
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dead letter", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	var a *Automata[context.Context, string]
+	var errBroken error
+	var failures int
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		errBroken = errors.New("downstream broken")
+		failures = 2
+
+		a = New[string](NewMemoryStore()).WithDeadLetter(MaxAttempts(2))
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			if failures > 0 {
+				failures--
+				return nil, errBroken
+			}
+
+			return a.NewTransition(StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+	})
+
+	It("dead letters an instance after the maximum number of attempts", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(errBroken))
+		Expect(err).ToNot(MatchError(ErrDeadLetter))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(errBroken))
+		Expect(err).To(MatchError(ErrDeadLetter))
+		Expect(IsRetryable(err)).To(BeFalse())
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.DeadLetter).To(BeTrue())
+		Expect(instance.Attempts).To(Equal(2))
+		Expect(instance.LastError).To(ContainSubstring("downstream broken"))
+
+		// a dead lettered instance is not executed anymore
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+
+		var deadLetterErr *DeadLetterError
+		Expect(errors.As(err, &deadLetterErr)).To(BeTrue())
+		Expect(deadLetterErr.InstanceId).To(Equal(instance.Id))
+		Expect(deadLetterErr.Err).To(BeNil())
+	})
+
+	It("dead letters an instance immediately on permanent errors", func() {
+		errBroken = Permanent(errBroken)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ErrDeadLetter))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.DeadLetter).To(BeTrue())
+		Expect(instance.Attempts).To(Equal(1))
+	})
+
+	It("lists and re-drives dead lettered instances", func() {
+		healthy, err := a.Start(ctx, StateB{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		for !instance.DeadLetter {
+			_, err = a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err).To(HaveOccurred())
+
+			instance, err = a.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		deadLetters, err := a.ListDeadLetters(ctx, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deadLetters).To(HaveLen(1))
+		Expect(deadLetters[0].Id).To(Equal(instance.Id))
+
+		runnable, err := a.List(ctx, ListQuery{DeadLetter: ExcludeDeadLetters})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runnable).To(HaveLen(1))
+		Expect(runnable[0].Id).To(Equal(healthy.Id))
+
+		instance, err = a.Redrive(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.DeadLetter).To(BeFalse())
+		Expect(instance.Attempts).To(Equal(0))
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+
+		deadLetters, err = a.ListDeadLetters(ctx, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deadLetters).To(BeEmpty())
+	})
+})
@@ -1,10 +1,20 @@
 package pee
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var ErrNoNextState = makeErr("transition did neither fail nor return a next state")
 var ErrTransitionReused = makeErr("transition must only run once and can not be reused")
 var ErrNoSuchInstance = makeErr("no such instance")
+var ErrNotSupported = makeErr("operation not supported by store")
+var ErrNoHandler = makeErr("no handler for state")
+var ErrDeadLetter = makeErr("instance is dead lettered")
+var ErrFinalState = makeErr("instance is in a final state")
+var ErrLimited = makeErr("limit of state reached")
 
 type Error struct {
 	error
@@ -22,3 +32,268 @@ func wrap(err error, message string, args ...interface{}) error {
 	err = fmt.Errorf("%s: %w", fmt.Sprintf(message, args...), err)
 	return Error{err}
 }
+
+// ErrorContext describes the Instance an error occurred for.
+type ErrorContext struct {
+	InstanceId InstanceId
+	Version    int
+	StateName  string
+}
+
+func errorContextOf(instance Instance) ErrorContext {
+	return ErrorContext{
+		InstanceId: instance.Id,
+		Version:    instance.Version,
+		StateName:  instance.StateName,
+	}
+}
+
+func (c ErrorContext) String() string {
+	return fmt.Sprintf("instance=%s, version=%d, state=%q", c.InstanceId, c.Version, c.StateName)
+}
+
+// HandlerError is returned if the Handler or the final Transform of a State fails.
+// It is retryable, unless the handlers error was marked using Permanent.
+type HandlerError struct {
+	ErrorContext
+	Err error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("handler failed (%s): %s", e.ErrorContext, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HandlerError) Retryable() bool {
+	return IsRetryable(e.Err)
+}
+
+// TransitionError is returned if an Action of a StateTransition fails.
+// It is retryable, unless the actions error was marked using Permanent.
+type TransitionError struct {
+	ErrorContext
+	Err error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("transition failed (%s): %s", e.ErrorContext, e.Err)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransitionError) Retryable() bool {
+	return IsRetryable(e.Err)
+}
+
+// StoreError is returned if an operation on the Store fails. It is retryable,
+// unless the instance does not exist or the operation is not supported by the Store.
+type StoreError struct {
+	ErrorContext
+
+	// Op is the name of the failed operation, e.g. "load" or "update".
+	Op string
+
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("store %s failed (%s): %s", e.Op, e.ErrorContext, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StoreError) Retryable() bool {
+	if errors.Is(e.Err, ErrNoSuchInstance) || errors.Is(e.Err, ErrNotSupported) {
+		return false
+	}
+
+	return IsRetryable(e.Err)
+}
+
+// SerializationError is returned if a State can not be serialized or deserialized.
+// It is never retryable.
+type SerializationError struct {
+	ErrorContext
+	Err error
+}
+
+func (e *SerializationError) Error() string {
+	return fmt.Sprintf("serialization failed (%s): %s", e.ErrorContext, e.Err)
+}
+
+func (e *SerializationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SerializationError) Retryable() bool {
+	return false
+}
+
+type classifiedError struct {
+	error
+	retryable bool
+}
+
+func (e classifiedError) Unwrap() error {
+	return e.error
+}
+
+func (e classifiedError) Retryable() bool {
+	return e.retryable
+}
+
+// Permanent marks the given error as permanent. A permanent error will
+// not go away by retrying the failed operation.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return classifiedError{error: err, retryable: false}
+}
+
+// Retryable marks the given error as retryable.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return classifiedError{error: err, retryable: true}
+}
+
+// IsRetryable returns true, if the operation that returned the given error might succeed
+// if retried. Errors can be explicitly classified using Permanent and Retryable.
+// Errors of this package that are not classified otherwise, as well as
+// canceled contexts, are considered to be permanent. All other errors are retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var classified interface{ Retryable() bool }
+	if errors.As(err, &classified) {
+		return classified.Retryable()
+	}
+
+	if errors.Is(err, ErrOptimisticLocking) {
+		return true
+	}
+
+	var automataErr Error
+	if errors.As(err, &automataErr) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	return true
+}
+
+// PanicError is returned if a Handler, Transform, Action or Middleware panicked
+// and panic recovery is enabled, see Automata.WithPanicRecovery. It is never retryable.
+type PanicError struct {
+	ErrorContext
+
+	// Value is the value passed to panic.
+	Value any
+
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic (%s): %v", e.ErrorContext, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func (e *PanicError) Retryable() bool {
+	return false
+}
+
+// DeadLetterError is returned if an Instance was dead lettered by the DeadLetterPolicy,
+// or if a dead lettered Instance is executed. It matches ErrDeadLetter and is never retryable.
+type DeadLetterError struct {
+	ErrorContext
+
+	// Err is the error that caused the Instance to be dead lettered.
+	// It is nil if an Instance was executed that was dead lettered before.
+	Err error
+}
+
+func (e *DeadLetterError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("instance is dead lettered (%s)", e.ErrorContext)
+	}
+
+	return fmt.Sprintf("instance dead lettered (%s): %s", e.ErrorContext, e.Err)
+}
+
+func (e *DeadLetterError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DeadLetterError) Is(target error) bool {
+	return target == ErrDeadLetter
+}
+
+func (e *DeadLetterError) Retryable() bool {
+	return false
+}
+
+// LimitError is returned if the Handler of a State was not run, because the concurrency or
+// rate limit of the State is reached, see WithConcurrencyLimit and WithRateLimit. The Instance
+// stays in its State. It matches ErrLimited and is always retryable.
+type LimitError struct {
+	ErrorContext
+
+	// Limit is the kind of the limit that was reached, either "concurrency" or "rate".
+	Limit string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit reached (%s)", e.Limit, e.ErrorContext)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+func (e *LimitError) Retryable() bool {
+	return true
+}
+
+// MaxStepsError is returned if an execution exceeds the maximum number of steps,
+// see Automata.WithMaxSteps. It is never retryable.
+type MaxStepsError struct {
+	ErrorContext
+
+	// MaxSteps is the maximum number of steps of an execution.
+	MaxSteps int
+
+	// Cycle contains the names of the states the Instance was cycling through, starting
+	// and ending with the current state. It is empty if no cycle was detected.
+	Cycle []string
+}
+
+func (e *MaxStepsError) Error() string {
+	message := fmt.Sprintf("execution exceeded %d steps (%s)", e.MaxSteps, e.ErrorContext)
+
+	if len(e.Cycle) > 0 {
+		message += ", cycle detected: " + strings.Join(e.Cycle, " -> ")
+	}
+
+	return message
+}
+
+func (e *MaxStepsError) Retryable() bool {
+	return false
+}
@@ -0,0 +1,129 @@
+package pee
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	type StateA struct {
+		State `name:"A"`
+		Error string
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateUnhandled struct {
+		State `name:"Unhandled"`
+	}
+
+	errHandler := errors.New("handler failed")
+	errAction := errors.New("action failed")
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			switch state.Error {
+			case "handler":
+				return nil, errHandler
+
+			case "permanent":
+				return nil, Permanent(errHandler)
+
+			case "action":
+				return a.NewTransition(StateB{}).
+					WithAction(func(ctx context.Context) error { return errAction }).
+					AsTuple()
+
+			case "unhandled":
+				return a.NewTransition(StateUnhandled{}).AsTuple()
+
+			default:
+				return a.NewTransition(StateB{}).AsTuple()
+			}
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateUnhandled) (string, error) {
+			return "", Permanent(ErrNoHandler)
+		})
+	})
+
+	execute := func(errorType string) error {
+		instance, err := a.Start(ctx, StateA{Error: errorType})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		return err
+	}
+
+	It("returns a retryable HandlerError if the handler fails", func() {
+		err := execute("handler")
+		Expect(err).To(MatchError(errHandler))
+
+		var handlerErr *HandlerError
+		Expect(errors.As(err, &handlerErr)).To(BeTrue())
+		Expect(handlerErr.ErrorContext).To(Equal(ErrorContext{InstanceId: "1", Version: 1, StateName: "A"}))
+		Expect(IsRetryable(err)).To(BeTrue())
+	})
+
+	It("respects errors marked as permanent", func() {
+		err := execute("permanent")
+		Expect(err).To(MatchError(errHandler))
+		Expect(IsRetryable(err)).To(BeFalse())
+	})
+
+	It("returns a TransitionError if an action fails", func() {
+		err := execute("action")
+		Expect(err).To(MatchError(errAction))
+
+		var transitionErr *TransitionError
+		Expect(errors.As(err, &transitionErr)).To(BeTrue())
+		Expect(transitionErr.StateName).To(Equal("A"))
+		Expect(IsRetryable(err)).To(BeTrue())
+	})
+
+	It("returns a permanent error for a final transform marked as permanent", func() {
+		err := execute("unhandled")
+		Expect(err).To(MatchError(ErrNoHandler))
+
+		var handlerErr *HandlerError
+		Expect(errors.As(err, &handlerErr)).To(BeTrue())
+		Expect(handlerErr.StateName).To(Equal("Unhandled"))
+		Expect(IsRetryable(err)).To(BeFalse())
+	})
+
+	It("returns a permanent StoreError for unknown instances", func() {
+		_, err := a.Load(ctx, "42")
+		Expect(err).To(MatchError(ErrNoSuchInstance))
+
+		var storeErr *StoreError
+		Expect(errors.As(err, &storeErr)).To(BeTrue())
+		Expect(storeErr.Op).To(Equal("load"))
+		Expect(storeErr.InstanceId).To(Equal(InstanceId("42")))
+		Expect(IsRetryable(err)).To(BeFalse())
+	})
+
+	It("classifies errors", func() {
+		Expect(IsRetryable(nil)).To(BeFalse())
+		Expect(IsRetryable(errors.New("some error"))).To(BeTrue())
+		Expect(IsRetryable(context.Canceled)).To(BeFalse())
+		Expect(IsRetryable(ErrOptimisticLocking)).To(BeTrue())
+		Expect(IsRetryable(ErrNoSuchInstance)).To(BeFalse())
+		Expect(IsRetryable(Retryable(ErrNoSuchInstance))).To(BeTrue())
+		Expect(IsRetryable(&SerializationError{Err: errors.New("invalid json")})).To(BeFalse())
+	})
+})
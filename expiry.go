@@ -0,0 +1,65 @@
+package pee
+
+import (
+	"context"
+	"time"
+)
+
+// ExpiryHandler is called instead of the Handler of the current State, if an Instance
+// passed its Deadline before reaching a final State. It returns the transition into the
+// next State, usually a final State. The Deadline of the Instance is cleared with the
+// transition, so the Instance does not expire again.
+type ExpiryHandler[TxContext context.Context] func(ctx context.Context, instance Instance) (*StateTransition[TxContext], error)
+
+// WithExpiry configures the ExpiryHandler for instances that passed their Deadline, see WithDeadline.
+// Expired instances are moved on by the ExpiryHandler the next time they are executed,
+// e.g. by a Runner. Without an ExpiryHandler, the Deadline of an Instance has no effect.
+func (a *Automata[TxContext, R]) WithExpiry(handler ExpiryHandler[TxContext]) *Automata[TxContext, R] {
+	a.expiry = handler
+	return a
+}
+
+// expire runs the ExpiryHandler for the given instance.
+func (a *Automata[TxContext, R]) expire(ctx context.Context, instance Instance) (*StateTransition[TxContext], error) {
+	var transition *StateTransition[TxContext]
+
+	err := a.around(ctx, Step{Kind: StepHandler, Instance: instance}, func(ctx context.Context) (err error) {
+		transition, err = a.expiry(ctx, instance)
+		return err
+	})
+
+	return transition, err
+}
+
+// PurgeCompleted removes all instances in a final State that were last updated before the
+// given time and returns the number of removed instances.
+// The Store must implement PurgeStore, otherwise ErrNotSupported is returned.
+func (a *Automata[TxContext, _]) PurgeCompleted(ctx TxContext, before time.Time) (int, error) {
+	store, ok := a.store.(PurgeStore[TxContext])
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	if len(a.finalStates) == 0 {
+		return 0, nil
+	}
+
+	query := PurgeQuery{StateNames: a.finalStateNames(), UpdatedBefore: before}
+
+	count, err := store.Purge(ctx, query)
+	if err != nil {
+		return 0, &StoreError{Op: "purge", Err: err}
+	}
+
+	return count, nil
+}
+
+// finalStateNames returns the names of all final states of the Automata.
+func (a *Automata[TxContext, _]) finalStateNames() []string {
+	names := make([]string, 0, len(a.finalStates))
+	for name := range a.finalStates {
+		names = append(names, name)
+	}
+
+	return names
+}
@@ -0,0 +1,97 @@
+package pee
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expiry", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateExpired struct {
+		State `name:"Expired"`
+	}
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateExpired) (string, error) {
+			return "expired", nil
+		})
+
+		a.WithExpiry(func(ctx context.Context, instance Instance) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateExpired{}).AsTuple()
+		})
+	})
+
+	It("persists the deadline of an instance", func() {
+		deadline := time.Now().Add(time.Hour)
+
+		instance, err := a.Start(ctx, StateA{}, WithDeadline(deadline))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.Deadline).To(Equal(deadline))
+		Expect(instance.Expired(time.Now())).To(BeFalse())
+		Expect(instance.Expired(deadline)).To(BeTrue())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+	})
+
+	It("moves an expired instance to the expiry state", func() {
+		instance, err := a.Start(ctx, StateA{}, WithDeadline(time.Now().Add(-time.Second)))
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("expired"))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("Expired"))
+		Expect(instance.Deadline).To(BeZero())
+	})
+
+	It("purges completed instances", func() {
+		completed, err := a.Start(ctx, StateB{})
+		Expect(err).ToNot(HaveOccurred())
+
+		running, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		count, err := a.PurgeCompleted(ctx, time.Now().Add(-time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(0))
+
+		count, err = a.PurgeCompleted(ctx, time.Now().Add(time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+
+		_, err = a.Load(ctx, completed.Id)
+		Expect(err).To(MatchError(ErrNoSuchInstance))
+
+		_, err = a.Load(ctx, running.Id)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
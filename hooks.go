@@ -0,0 +1,65 @@
+package pee
+
+import (
+	"context"
+)
+
+// Hooks are called at specific points during the execution of an Instance.
+// All hooks are optional. Hooks must not fail and should return quickly.
+type Hooks struct {
+	// BeforeStep is called before the handler or the final transform of the current
+	// State of the Instance is executed.
+	BeforeStep func(ctx context.Context, instance Instance)
+
+	// AfterTransition is called after a transition was committed to the Store.
+	// The instances StateName fields give the names of the previous and the new State.
+	AfterTransition func(ctx context.Context, from, to Instance)
+
+	// OnError is called with the error returned by Automata.Execute and the
+	// latest known Instance.
+	OnError func(ctx context.Context, instance Instance, err error)
+
+	// OnFinal is called after the Instance reached a final State and its
+	// Transform returned successfully.
+	OnFinal func(ctx context.Context, instance Instance)
+}
+
+// WithHooks adds the given Hooks to the Automata. Hooks are called in the order they were added.
+func (a *Automata[TxContext, R]) WithHooks(hooks Hooks) *Automata[TxContext, R] {
+	a.hooks = append(a.hooks, hooks)
+	return a
+}
+
+type hookList []Hooks
+
+func (h hookList) beforeStep(ctx context.Context, instance Instance) {
+	for _, hooks := range h {
+		if hooks.BeforeStep != nil {
+			hooks.BeforeStep(ctx, instance)
+		}
+	}
+}
+
+func (h hookList) afterTransition(ctx context.Context, from, to Instance) {
+	for _, hooks := range h {
+		if hooks.AfterTransition != nil {
+			hooks.AfterTransition(ctx, from, to)
+		}
+	}
+}
+
+func (h hookList) onError(ctx context.Context, instance Instance, err error) {
+	for _, hooks := range h {
+		if hooks.OnError != nil {
+			hooks.OnError(ctx, instance, err)
+		}
+	}
+}
+
+func (h hookList) onFinal(ctx context.Context, instance Instance) {
+	for _, hooks := range h {
+		if hooks.OnFinal != nil {
+			hooks.OnFinal(ctx, instance)
+		}
+	}
+}
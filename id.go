@@ -0,0 +1,37 @@
+package pee
+
+import (
+	"crypto/rand"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+// InstanceId identifies an Instance within a Store. Ids are opaque strings, which
+// allows a Store to use integer keys assigned by the database as well as
+// UUIDs or ULIDs generated by the client before inserting a new instance.
+type InstanceId string
+
+// IntId converts an integer id, as assigned by an integer keyed table, to an InstanceId.
+func IntId(id int) InstanceId {
+	return InstanceId(strconv.Itoa(id))
+}
+
+func (id InstanceId) String() string {
+	return string(id)
+}
+
+// IdGenerator generates a new unique InstanceId for a new Instance.
+type IdGenerator func() InstanceId
+
+// UUIDGenerator generates random (version 4) UUIDs.
+func UUIDGenerator() InstanceId {
+	return InstanceId(uuid.NewString())
+}
+
+// ULIDGenerator generates lexicographically sortable ULIDs using the current time.
+func ULIDGenerator() InstanceId {
+	return InstanceId(ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader).String())
+}
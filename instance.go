@@ -2,16 +2,94 @@ package pee
 
 import (
 	"fmt"
+	"time"
 )
 
 // Instance represents the state of an instance of an Automata. The instance is
 // immutable. Furthermore it is versioned to handle optimistic locking.
 type Instance struct {
-	Id      int
+	Id      InstanceId
 	Version int
 	State   State
+
+	// StateName is the name of the current State, see NameOf.
+	StateName string
+
+	// Labels are arbitrary key value pairs that were provided when starting the Instance.
+	Labels map[string]string
+
+	// CreatedAt is the time the instance was created in the Store.
+	CreatedAt time.Time
+
+	// UpdatedAt is the time of the last transition of this instance.
+	UpdatedAt time.Time
+
+	// Attempts is the number of failed attempts to leave the current State.
+	// Failed attempts are only tracked if the Store implements FailureStore.
+	Attempts int
+
+	// LastError is the error message of the last failed attempt in the current State.
+	LastError string
+
+	// LastErrorStack is the stack trace of the last failed attempt, if it was caused by a panic.
+	LastErrorStack string
+
+	// DeadLetter is true if the Instance failed permanently. A dead lettered Instance
+	// is not executed until it is re-driven, see Automata.Redrive.
+	DeadLetter bool
+
+	// Deadline is the time the Instance expires, zero if the Instance never expires.
+	// See WithDeadline and Automata.WithExpiry.
+	Deadline time.Time
+
+	// Priority of the Instance, see WithPriority.
+	Priority int
+
+	// Partition of the Instance, see Automata.WithPartitions.
+	Partition int
+
+	// TraceContext is the propagated trace context of the last transition
+	// of this instance, see Automata.WithTraceContext.
+	TraceContext map[string]string
+
+	// the persisted result of the final state, see Automata.WithResultPersistence
+	result []byte
+}
+
+// Attempt returns the number of the current attempt to leave the current State, starting at 1.
+func (i Instance) Attempt() int {
+	return i.Attempts + 1
+}
+
+// Expired returns true if the Instance has a Deadline that passed at the given time.
+func (i Instance) Expired(now time.Time) bool {
+	return !i.Deadline.IsZero() && !now.Before(i.Deadline)
 }
 
 func (i Instance) String() string {
-	return fmt.Sprintf("Instance(id=%d, version=%d)", i.Id, i.Version)
+	return fmt.Sprintf("Instance(id=%s, version=%d)", i.Id, i.Version)
+}
+
+func newInstance(serializedInstance *SerializedInstance, state State) Instance {
+	return Instance{
+		Id:        serializedInstance.Id,
+		Version:   serializedInstance.Version,
+		State:     state,
+		StateName: serializedInstance.StateName,
+		Labels:    serializedInstance.Labels,
+		CreatedAt: serializedInstance.CreatedAt,
+		UpdatedAt: serializedInstance.UpdatedAt,
+		Attempts:  serializedInstance.Attempts,
+
+		LastError:      serializedInstance.LastError,
+		LastErrorStack: serializedInstance.LastErrorStack,
+		DeadLetter:     serializedInstance.DeadLetter,
+		Deadline:       serializedInstance.Deadline,
+		Priority:       serializedInstance.Priority,
+		Partition:      serializedInstance.Partition,
+
+		TraceContext: serializedInstance.TraceContext,
+
+		result: serializedInstance.Result,
+	}
 }
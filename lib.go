@@ -3,7 +3,10 @@ package pee
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"time"
 )
 
 type Handler[TxContext context.Context, S State] func(ctx context.Context, state S) (*StateTransition[TxContext], error)
@@ -14,9 +17,25 @@ type RunInTx[TxContext context.Context, R any] func(ctx context.Context, fn func
 
 type Automata[TxContext context.Context, R any] struct {
 	store             Store[TxContext]
+	idGenerator       IdGenerator
 	states            map[string]Handler[TxContext, State]
 	finalStates       map[string]Transform[State, R]
 	stateConstructors map[string]func([]byte) (State, error)
+	stateOptions      map[string]stateOptions
+	limiters          map[string]*limiter
+	middlewares       []Middleware
+	hooks             hookList
+	traceContext      func(ctx context.Context) map[string]string
+	logger            *slog.Logger
+	panicRecovery     *panicRecovery
+	deadLetterPolicy  DeadLetterPolicy
+	expiry            ExpiryHandler[TxContext]
+	maxSteps          int
+	notifier          Notifier
+	awaitInterval     time.Duration
+	persistResults    bool
+	partitions        int
+	partitionLabel    string
 }
 
 func (a *Automata[TxContext, T]) validateState(state State) error {
@@ -31,45 +50,119 @@ func (a *Automata[TxContext, T]) validateState(state State) error {
 }
 
 // Start creates a new Instance of an Automata with the given initial State in the database.
-func (a *Automata[TxContext, _]) Start(ctx TxContext, initialState State) (Instance, error) {
-	serializedState, err := serializeState(initialState)
+// The Instance can be further configured using StartOption values like WithLabels.
+func (a *Automata[TxContext, _]) Start(ctx TxContext, initialState State, opts ...StartOption) (Instance, error) {
+	instance, err := a.newSerializedInstance(ctx, initialState, opts)
 	if err != nil {
-		return Instance{}, fmt.Errorf("serialize state: %w", err)
+		return Instance{}, err
 	}
 
-	serializedInstance, err := a.store.Create(ctx, serializedState)
+	serializedInstance, err := a.store.Create(ctx, instance)
 	if err != nil {
-		return Instance{}, err
+		errCtx := ErrorContext{InstanceId: instance.Id, StateName: instance.StateName}
+		return Instance{}, &StoreError{ErrorContext: errCtx, Op: "create", Err: err}
+	}
+
+	return newInstance(serializedInstance, initialState), nil
+}
+
+// newSerializedInstance prepares a new instance with the given initial state to be created in the store.
+func (a *Automata[TxContext, _]) newSerializedInstance(ctx TxContext, initialState State, opts []StartOption) (SerializedInstance, error) {
+	options := applyStartOptions(opts)
+
+	id := options.id
+	if id == "" && a.idGenerator != nil {
+		id = a.idGenerator()
 	}
 
-	instance := Instance{
-		Id:      serializedInstance.Id,
-		Version: serializedInstance.Version,
-		State:   initialState,
+	serializedState, err := serializeState(initialState)
+	if err != nil {
+		errCtx := ErrorContext{InstanceId: id, StateName: NameOf(initialState)}
+		return SerializedInstance{}, &SerializationError{ErrorContext: errCtx, Err: err}
+	}
+
+	instance := SerializedInstance{
+		Id:        id,
+		State:     serializedState,
+		StateName: NameOf(initialState),
+		Labels:    options.labels,
+		Deadline:  options.deadline,
+		Priority:  options.priority,
+		Partition: a.partitionOf(id, options.labels),
+
+		TraceContext: a.injectTraceContext(ctx),
 	}
 
 	return instance, nil
 }
 
 // Load gets an Instance of this Automata with the given id from the database.
-func (a *Automata[TxContext, _]) Load(ctx TxContext, id int) (Instance, error) {
+func (a *Automata[TxContext, _]) Load(ctx TxContext, id InstanceId) (Instance, error) {
 	serializedInstance, err := a.store.Load(ctx, id)
 	if err != nil {
-		return Instance{}, err
+		return Instance{}, &StoreError{ErrorContext: ErrorContext{InstanceId: id}, Op: "load", Err: err}
 	}
 
+	return a.deserializeInstance(serializedInstance)
+}
+
+// deserializeInstance deserializes the state of the given SerializedInstance.
+func (a *Automata[TxContext, _]) deserializeInstance(serializedInstance *SerializedInstance) (Instance, error) {
 	state, err := a.deserializeState(serializedInstance.State)
 	if err != nil {
-		return Instance{}, fmt.Errorf("deserialize state: %w", err)
+		errCtx := ErrorContext{
+			InstanceId: serializedInstance.Id,
+			Version:    serializedInstance.Version,
+			StateName:  serializedInstance.StateName,
+		}
+
+		return Instance{}, &SerializationError{ErrorContext: errCtx, Err: err}
 	}
 
-	instance := Instance{
-		Id:      serializedInstance.Id,
-		Version: serializedInstance.Version,
-		State:   state,
+	return newInstance(serializedInstance, state), nil
+}
+
+// List returns all instances of this Automata that match the given query.
+// The Store must implement ListStore, otherwise ErrNotSupported is returned.
+func (a *Automata[TxContext, _]) List(ctx TxContext, query ListQuery) ([]Instance, error) {
+	store, ok := a.store.(ListStore[TxContext])
+	if !ok {
+		return nil, ErrNotSupported
 	}
 
-	return instance, nil
+	serializedInstances, err := store.List(ctx, query)
+	if err != nil {
+		return nil, &StoreError{Op: "list", Err: err}
+	}
+
+	instances := make([]Instance, 0, len(serializedInstances))
+
+	for _, serializedInstance := range serializedInstances {
+		instance, err := a.deserializeInstance(serializedInstance)
+		if err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// CountByState returns the number of instances of this Automata per state name.
+// The Store must implement CountStore, otherwise ErrNotSupported is returned.
+func (a *Automata[TxContext, _]) CountByState(ctx TxContext) (map[string]int, error) {
+	store, ok := a.store.(CountStore[TxContext])
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	counts, err := store.CountByState(ctx)
+	if err != nil {
+		return nil, &StoreError{Op: "count", Err: err}
+	}
+
+	return counts, nil
 }
 
 // New creates a new Automata that lives in the given database table.
@@ -80,75 +173,293 @@ func New[R any, TxContext context.Context](store Store[TxContext]) *Automata[TxC
 		states:            map[string]Handler[TxContext, State]{},
 		finalStates:       map[string]Transform[State, R]{},
 		stateConstructors: map[string]func([]byte) (State, error){},
+		stateOptions:      map[string]stateOptions{},
+		limiters:          map[string]*limiter{},
+	}
+}
+
+// WithIdGenerator configures the Automata to generate ids for new instances on the client,
+// e.g. using UUIDGenerator or ULIDGenerator. Without an IdGenerator, the Store assigns the ids.
+func (a *Automata[TxContext, R]) WithIdGenerator(generator IdGenerator) *Automata[TxContext, R] {
+	a.idGenerator = generator
+	return a
+}
+
+// WithTraceContext configures a function to extract the trace context from the context
+// of a transition. The trace context is persisted with the Instance, so that later
+// executions of the Instance can be linked to previous ones, even across processes.
+func (a *Automata[TxContext, R]) WithTraceContext(inject func(ctx context.Context) map[string]string) *Automata[TxContext, R] {
+	a.traceContext = inject
+	return a
+}
+
+func (a *Automata[TxContext, R]) injectTraceContext(ctx context.Context) map[string]string {
+	if a.traceContext == nil {
+		return nil
 	}
+
+	return a.traceContext(ctx)
 }
 
+// Execute runs the given Instance until it reaches a final State and returns the
+// result of the final states Transform. Every transition is applied in a new
+// transaction created by runInTx.
 func (a *Automata[TxContext, R]) Execute(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance) (R, error) {
+	var result R
+
+	err := a.aroundExecute(ctx, &instance, func(ctx context.Context) (err error) {
+		result, err = a.execute(ctx, runInTx, &instance)
+		return err
+	})
+
+	return result, err
+}
+
+// aroundExecute runs the given execution of the instance as a StepExecute
+// and reports a failed execution to the hooks. Executions deferred by a limit
+// are not reported as failed.
+func (a *Automata[TxContext, R]) aroundExecute(ctx context.Context, instance *Instance, fn func(ctx context.Context) error) error {
+	return a.around(ctx, Step{Kind: StepExecute, Instance: *instance}, func(ctx context.Context) error {
+		err := fn(ctx)
+		if err != nil && !errors.Is(err, ErrLimited) {
+			a.hooks.onError(ctx, *instance, err)
+		}
+
+		return err
+	})
+}
+
+// execute runs the instance until it reaches a final state. The instance is updated
+// after each transition.
+func (a *Automata[TxContext, R]) execute(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance) (R, error) {
 	var nilT R
 
+	// dead lettered instances need to be re-driven first
+	if instance.DeadLetter {
+		return nilT, &DeadLetterError{ErrorContext: errorContextOf(*instance)}
+	}
+
+	guard := newStepGuard(a.maxSteps)
+
 	for {
-		name := NameOf(instance.State)
+		a.hooks.beforeStep(ctx, *instance)
 
 		// check if we have reached the final state
-		if final, ok := a.finalStates[name]; ok {
-			return final(ctx, instance.State)
+		if final, ok := a.finalStates[NameOf(instance.State)]; ok {
+			result, err := a.finalResult(ctx, runInTx, instance, final)
+			if err != nil {
+				return nilT, err
+			}
+
+			a.hooks.onFinal(ctx, *instance)
+
+			return result, nil
 		}
 
-		// check that we have a state handler
-		handler, ok := a.states[name]
-		if !ok {
-			return nilT, fmt.Errorf("no event handler for state %q", name)
+		// stop before the next step, if the execution was canceled
+		if err := ctx.Err(); err != nil {
+			return nilT, err
 		}
 
-		// execute the handler to get a transition
-		transition, err := handler(ctx, instance.State)
-		if err != nil {
+		if err := guard.visit(*instance); err != nil {
 			return nilT, err
 		}
 
-		// run a transaction to execute the state update
-		newInstance, err := a.applyTransition(ctx, runInTx, instance, transition)
-		if err != nil {
+		if err := a.step(ctx, runInTx, instance); err != nil {
 			return nilT, err
 		}
+	}
+}
+
+// step runs the handler of the instances current state and applies the transition
+// into the next state. The instance is updated after the transition.
+func (a *Automata[TxContext, R]) step(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance) error {
+	source, transition, err := a.nextTransition(ctx, runInTx, *instance)
+	if err != nil {
+		return err
+	}
+
+	return a.commitTransition(ctx, runInTx, instance, source, transition)
+}
+
+// nextTransition runs the handler of the instances current state and returns the transition into
+// the next state, together with the instance the transition must be applied to. A failure of the
+// handler is recorded.
+func (a *Automata[TxContext, R]) nextTransition(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance) (Instance, *StateTransition[TxContext], error) {
+	// expired instances are moved on by the expiry handler instead
+	expired := a.expiry != nil && instance.Expired(time.Now())
+
+	// check that we have a state handler
+	handler, ok := a.states[NameOf(instance.State)]
+	if !ok && !expired {
+		return instance, nil, &HandlerError{ErrorContext: errorContextOf(instance), Err: ErrNoHandler}
+	}
+
+	// the instance to update with the next state
+	source := instance
+
+	// execute the handler to get a transition
+	var transition *StateTransition[TxContext]
+	var err error
+
+	if expired {
+		// the deadline is cleared, so the instance does not expire again
+		source.Deadline = time.Time{}
+		transition, err = a.expire(ctx, source)
+	} else {
+		release, limitErr := a.acquireLimits(ctx, runInTx, source)
+		if limitErr != nil {
+			// the instance is deferred, this is not a failed attempt
+			return instance, nil, limitErr
+		}
+
+		transition, err = func() (*StateTransition[TxContext], error) {
+			// release the limits even if the handler panics
+			defer release()
+			return a.handle(ctx, handler, source)
+		}()
+	}
+
+	if failure, ok := a.failureTransition(instance, err); ok {
+		// the handler panicked, move the instance to the failure state
+		transition, err = failure, nil
+	}
 
-		// use the new instance from now on
-		instance = newInstance
+	if err != nil {
+		err = &HandlerError{ErrorContext: errorContextOf(instance), Err: err}
+		return instance, nil, a.recordFailure(ctx, runInTx, instance, err)
+	}
+
+	return source, transition, nil
+}
+
+// commitTransition applies the transition to the source of the instance in a new transaction.
+// A failure of the transition is recorded. The instance is updated after the transition.
+func (a *Automata[TxContext, R]) commitTransition(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance, source Instance, transition *StateTransition[TxContext]) error {
+	// run a transaction to execute the state update
+	newInstance, err := a.applyTransition(ctx, runInTx, source, transition)
+
+	if failure, ok := a.failureTransition(*instance, err); ok {
+		// an action panicked, move the instance to the failure state in a new transaction
+		newInstance, err = a.applyTransition(ctx, runInTx, source, failure)
+	}
+
+	if err != nil {
+		return a.recordFailure(ctx, runInTx, *instance, err)
 	}
+
+	a.hooks.afterTransition(ctx, *instance, newInstance)
+	reportProgress(ctx, newInstance)
+
+	// use the new instance from now on
+	*instance = newInstance
+
+	return nil
+}
+
+// handle runs the handler of the instances state and returns the transition into the next state.
+func (a *Automata[TxContext, R]) handle(ctx context.Context, handler Handler[TxContext, State], instance Instance) (*StateTransition[TxContext], error) {
+	options := a.stateOptions[NameOf(instance.State)]
+
+	var transition *StateTransition[TxContext]
+	var timedOut bool
+
+	err := a.around(ctx, Step{Kind: StepHandler, Instance: instance}, func(ctx context.Context) (err error) {
+		transition, timedOut, err = callHandler(ctx, handler, instance.State, options.timeout)
+		return err
+	})
+
+	if err != nil && timedOut && options.timeoutState != nil {
+		// the handler exceeded its timeout, move the instance to the timeout state
+		return Transition[TxContext](options.timeoutState), nil
+	}
+
+	return transition, err
+}
+
+// callHandler calls the handler with a deadline, if a timeout is given. It also returns true,
+// if the deadline exceeded. An exceeded deadline of the parent context is not reported.
+// The handler runs in the callers goroutine and is waited for, even if it ignores the deadline.
+func callHandler[TxContext context.Context](ctx context.Context, handler Handler[TxContext, State], state State, timeout time.Duration) (*StateTransition[TxContext], bool, error) {
+	if timeout <= 0 {
+		transition, err := handler(ctx, state)
+		return transition, false, err
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	transition, err := handler(timeoutCtx, state)
+
+	timedOut := errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+	return transition, timedOut, err
 }
 
 func (a *Automata[TxContext, R]) applyTransition(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance, transition *StateTransition[TxContext]) (Instance, error) {
-	return runInTx(ctx, func(ctx TxContext) (Instance, error) {
+	return runInTx(withInstance(ctx, instance), func(tx TxContext) (Instance, error) {
+		return a.applyTransitionIn(tx, instance, transition)
+	})
+}
+
+// applyTransitionIn applies the transition to the instance within the given transaction.
+func (a *Automata[TxContext, R]) applyTransitionIn(tx TxContext, instance Instance, transition *StateTransition[TxContext]) (Instance, error) {
+	var newInstance Instance
+
+	step := Step{Kind: StepTransition, Instance: instance, result: &newInstance}
+
+	err := a.around(tx, step, func(ctx context.Context) error {
+		tx := withContext(tx, ctx)
+
 		// apply transition to get the next state
-		nextState, err := transition.applyIn(ctx)
+		nextState, err := transition.applyIn(tx)
 		if err != nil {
-			return Instance{}, err
+			return &TransitionError{ErrorContext: errorContextOf(instance), Err: err}
 		}
 
 		// update the instance
-		return a.updateInstance(ctx, instance, nextState)
+		newInstance, err = a.updateInstance(tx, withPriority(instance, transition.priority), nextState)
+		return err
 	})
+
+	return newInstance, err
+}
+
+// withPriority returns the instance with the given priority, if not nil.
+func withPriority(instance Instance, priority *int) Instance {
+	if priority != nil {
+		instance.Priority = *priority
+	}
+
+	return instance
 }
 
 func (a *Automata[TxContext, _]) updateInstance(ctx TxContext, instance Instance, newState State) (Instance, error) {
 	// serialize the new state
 	serializedState, err := serializeState(newState)
 	if err != nil {
-		return Instance{}, fmt.Errorf("serialize state in transition: %w", err)
+		return Instance{}, &SerializationError{ErrorContext: errorContextOf(instance), Err: err}
 	}
 
-	serializedInstance, err := a.store.Update(ctx, instance.Id, instance.Version, serializedState)
-	if err != nil {
-		return Instance{}, err
-	}
+	serializedInstance, err := a.store.Update(ctx, SerializedInstance{
+		Id:        instance.Id,
+		Version:   instance.Version,
+		State:     serializedState,
+		StateName: NameOf(newState),
+		Labels:    instance.Labels,
+		CreatedAt: instance.CreatedAt,
+		UpdatedAt: instance.UpdatedAt,
+		Deadline:  instance.Deadline,
+		Priority:  instance.Priority,
+		Partition: instance.Partition,
+
+		TraceContext: a.injectTraceContext(ctx),
+	})
 
-	newInstance := Instance{
-		Id:      serializedInstance.Id,
-		Version: serializedInstance.Version,
-		State:   newState,
+	if err != nil {
+		return Instance{}, &StoreError{ErrorContext: errorContextOf(instance), Op: "update", Err: err}
 	}
 
-	return newInstance, nil
+	return newInstance(serializedInstance, newState), nil
 }
 
 func (a *Automata[TxContext, _]) deserializeState(serializedState []byte) (State, error) {
@@ -182,10 +493,20 @@ func (a *Automata[TxContext, R]) NewTransition(newState State) *StateTransition[
 // an Instance of this Automata is in the given State. The handlers state argument
 // must be a struct of type State.
 // Every state can only be registered once, otherwise this method will panic.
-func AddState[S State, R any, TxContext context.Context](a *Automata[TxContext, R], handler Handler[TxContext, S]) {
+// The execution of the Handler can be configured using StateOption values like WithTimeout
+// or WithConcurrencyLimit.
+func AddState[S State, R any, TxContext context.Context](a *Automata[TxContext, R], handler Handler[TxContext, S], opts ...StateOption) {
 	addStateInternal[S](a, a.states, func(ctx context.Context, state State) (*StateTransition[TxContext], error) {
 		return handler(ctx, state.(S))
 	})
+
+	var stateInstance S
+	options := applyStateOptions(opts)
+	a.stateOptions[NameOf(stateInstance)] = options
+
+	if options.limited() {
+		a.limiters[NameOf(stateInstance)] = &limiter{options: options}
+	}
 }
 
 // AddFinalState adds a new Transform to the Automata. The Transform will be called
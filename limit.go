@@ -0,0 +1,167 @@
+package pee
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultSlotLease is the time a slot of a shared concurrency limit is held, if the
+// process dies before releasing it. States with a timeout hold their slots for the
+// timeout instead, see WithTimeout.
+const DefaultSlotLease = 5 * time.Minute
+
+// tokenBucket holds up to limit tokens and is refilled with limit tokens per interval.
+// A new bucket is full.
+type tokenBucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+// take refills the bucket and takes a token, if available.
+func (b *tokenBucket) take(limit int, per time.Duration, now time.Time) bool {
+	if b.refilled.IsZero() {
+		b.tokens = float64(limit)
+	} else {
+		elapsed := now.Sub(b.refilled)
+		b.tokens = min(float64(limit), b.tokens+float64(limit)*elapsed.Seconds()/per.Seconds())
+	}
+
+	b.refilled = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// limiter enforces the concurrency and rate limit of a state within the process.
+type limiter struct {
+	options stateOptions
+
+	mu      sync.Mutex
+	running int
+	bucket  tokenBucket
+}
+
+// acquire takes a slot and a token of the limiter. It returns the kind
+// of the limit that was reached, if any.
+func (l *limiter) acquire(now time.Time) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.options.concurrency > 0 && l.running >= l.options.concurrency {
+		return "concurrency", false
+	}
+
+	if l.options.rate > 0 && l.options.ratePer > 0 && !l.bucket.take(l.options.rate, l.options.ratePer, now) {
+		return "rate", false
+	}
+
+	l.running++
+	return "", true
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.running--
+}
+
+// acquireLimits acquires the limits of the instances state before running its handler.
+// The returned function releases the limits once the handler returned.
+func (a *Automata[TxContext, R]) acquireLimits(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance) (func(), error) {
+	options := a.stateOptions[NameOf(instance.State)]
+	if !options.limited() {
+		return func() {}, nil
+	}
+
+	if options.sharedKey != "" {
+		return a.acquireSharedLimits(ctx, runInTx, instance, options)
+	}
+
+	limit, ok := a.limiters[NameOf(instance.State)].acquire(time.Now())
+	if !ok {
+		return nil, &LimitError{ErrorContext: errorContextOf(instance), Limit: limit}
+	}
+
+	return a.limiters[NameOf(instance.State)].release, nil
+}
+
+// acquireSharedLimits acquires the limits of the instances state through the LimitStore.
+func (a *Automata[TxContext, R]) acquireSharedLimits(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance, options stateOptions) (func(), error) {
+	errCtx := errorContextOf(instance)
+
+	store, ok := a.store.(LimitStore[TxContext])
+	if !ok {
+		return nil, &StoreError{ErrorContext: errCtx, Op: "acquire limit", Err: ErrNotSupported}
+	}
+
+	lease := DefaultSlotLease
+	if options.timeout > 0 {
+		lease = options.timeout
+	}
+
+	owner := string(UUIDGenerator())
+
+	var limit string
+
+	_, err := runInTx(ctx, func(tx TxContext) (Instance, error) {
+		if options.concurrency > 0 {
+			acquired, err := store.AcquireSlot(tx, options.sharedKey, options.concurrency, owner, time.Now().Add(lease))
+			if err != nil || !acquired {
+				limit = "concurrency"
+				return Instance{}, err
+			}
+		}
+
+		if options.rate > 0 && options.ratePer > 0 {
+			acquired, err := store.TakeToken(tx, options.sharedKey, options.rate, options.ratePer)
+			if err != nil || !acquired {
+				limit = "rate"
+
+				if err == nil && options.concurrency > 0 {
+					// give back the slot taken before
+					err = store.ReleaseSlot(tx, options.sharedKey, owner)
+				}
+
+				return Instance{}, err
+			}
+		}
+
+		limit = ""
+		return Instance{}, nil
+	})
+
+	if err != nil {
+		return nil, &StoreError{ErrorContext: errCtx, Op: "acquire limit", Err: err}
+	}
+
+	if limit != "" {
+		return nil, &LimitError{ErrorContext: errCtx, Limit: limit}
+	}
+
+	if options.concurrency <= 0 {
+		return func() {}, nil
+	}
+
+	release := func() {
+		// release the slot even if the execution was canceled
+		ctx := context.WithoutCancel(ctx)
+
+		_, err := runInTx(ctx, func(tx TxContext) (Instance, error) {
+			return Instance{}, store.ReleaseSlot(tx, options.sharedKey, owner)
+		})
+
+		if err != nil {
+			a.loggerOrDefault().WarnContext(ctx, "Releasing slot failed",
+				slog.String("key", options.sharedKey), slog.Any("error", err))
+		}
+	}
+
+	return release, nil
+}
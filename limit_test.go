@@ -0,0 +1,221 @@
+package pee
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State limits", func() {
+	type StateA struct {
+		State `name:"A"`
+		Block bool
+		Panic bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	var store Store[context.Context]
+	var entered chan struct{}
+	var unblock chan struct{}
+
+	ctx := context.Background()
+
+	newAutomata := func(opts ...StateOption) *Automata[context.Context, string] {
+		a := New[string](store).WithDeadLetter(MaxAttempts(1))
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			if state.Block {
+				entered <- struct{}{}
+				<-unblock
+			}
+
+			if state.Panic {
+				panic("handler failed")
+			}
+
+			return Transition[context.Context](StateB{}).AsTuple()
+		}, opts...)
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		return a
+	}
+
+	BeforeEach(func() {
+		store = NewMemoryStore()
+		entered = make(chan struct{})
+		unblock = make(chan struct{})
+	})
+
+	// executeBlocked starts an execution that blocks in the handler until unblock is closed.
+	executeBlocked := func(a *Automata[context.Context, string]) <-chan error {
+		instance, err := a.Start(ctx, StateA{Block: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		done := make(chan error, 1)
+
+		go func() {
+			_, err := a.Execute(ctx, DummyRunInTx, instance)
+			done <- err
+		}()
+
+		Eventually(entered).Should(Receive())
+
+		return done
+	}
+
+	It("defers executions above the concurrency limit", func() {
+		a := newAutomata(WithConcurrencyLimit(1))
+
+		done := executeBlocked(a)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ErrLimited))
+		Expect(IsRetryable(err)).To(BeTrue())
+
+		var limitErr *LimitError
+		Expect(err).To(BeAssignableToTypeOf(limitErr))
+		Expect(err.(*LimitError).Limit).To(Equal("concurrency"))
+
+		// the deferred execution is not a failed attempt
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("A"))
+		Expect(instance.Attempts).To(Equal(0))
+		Expect(instance.DeadLetter).To(BeFalse())
+
+		close(unblock)
+		Eventually(done).Should(Receive(BeNil()))
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+	})
+
+	It("releases the concurrency limit if the handler panics", func() {
+		a := newAutomata(WithConcurrencyLimit(1))
+
+		instance, err := a.Start(ctx, StateA{Panic: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(func() { _, _ = a.Execute(ctx, DummyRunInTx, instance) }).To(PanicWith("handler failed"))
+
+		instance, err = a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+	})
+
+	It("defers executions above the rate limit", func() {
+		a := newAutomata(WithRateLimit(2, time.Hour))
+
+		var errs []error
+
+		for i := 0; i < 3; i++ {
+			instance, err := a.Start(ctx, StateA{})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = a.Execute(ctx, DummyRunInTx, instance)
+			errs = append(errs, err)
+		}
+
+		Expect(errs[0]).ToNot(HaveOccurred())
+		Expect(errs[1]).ToNot(HaveOccurred())
+		Expect(errs[2]).To(MatchError(ErrLimited))
+		Expect(errs[2].(*LimitError).Limit).To(Equal("rate"))
+	})
+
+	It("refills the token bucket over time", func() {
+		a := newAutomata(WithRateLimit(1, 20*time.Millisecond))
+
+		first, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, first)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, second)
+		Expect(err).To(MatchError(ErrLimited))
+
+		Eventually(func() error {
+			_, err := a.Execute(ctx, DummyRunInTx, second)
+			return err
+		}).Should(Succeed())
+	})
+
+	It("lets runners retry limited instances with a later poll", func() {
+		a := newAutomata(WithRateLimit(1, time.Hour))
+
+		for i := 0; i < 3; i++ {
+			_, err := a.Start(ctx, StateA{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		runner := NewRunner(a, DummyRunInTx, RunnerConfig{})
+
+		executed, err := runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(executed).To(Equal(1))
+
+		counts, err := a.CountByState(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(counts).To(Equal(map[string]int{"A": 2, "B": 1}))
+
+		deadLetters, err := a.ListDeadLetters(ctx, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deadLetters).To(BeEmpty())
+	})
+
+	It("shares limits through the store", func() {
+		first := newAutomata(WithConcurrencyLimit(1), WithSharedLimits("api"))
+		second := newAutomata(WithConcurrencyLimit(1), WithSharedLimits("api"))
+
+		done := executeBlocked(first)
+
+		instance, err := second.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = second.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ErrLimited))
+
+		close(unblock)
+		Eventually(done).Should(Receive(BeNil()))
+
+		// the slot was released
+		result, err := second.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+	})
+
+	It("shares rate limits through the store", func() {
+		first := newAutomata(WithRateLimit(1, time.Hour), WithSharedLimits("api"))
+		second := newAutomata(WithRateLimit(1, time.Hour), WithSharedLimits("api"))
+
+		instance, err := first.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = first.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = second.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = second.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ErrLimited))
+	})
+})
@@ -0,0 +1,80 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// WithLogger configures a structured logger for the Automata. The Automata logs
+// every step of an execution with the fields "instance_id", "version", "state" and "step".
+// Successful transitions are logged on info level, the remaining steps on debug level.
+// Failed executions are logged on error level.
+func (a *Automata[TxContext, R]) WithLogger(logger *slog.Logger) *Automata[TxContext, R] {
+	a.logger = logger
+	return a
+}
+
+// Logger returns the logger for the current step of an Instance, pre-populated with the
+// fields of the Instance. If no logger is configured for the Automata, slog.Default
+// is returned.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// logStep is a Middleware that logs the given step and provides the
+// logger for the step in the context.
+func (a *Automata[TxContext, R]) logStep(ctx context.Context, step Step, next func(ctx context.Context) error) error {
+	logger := a.logger.With(
+		slog.String("instance_id", step.Instance.Id.String()),
+		slog.Int("version", step.Instance.Version),
+		slog.String("state", step.Instance.StateName),
+		slog.String("step", step.Kind.String()),
+	)
+
+	switch step.Kind {
+	case StepExecute:
+		logger.DebugContext(ctx, "Execute instance")
+	case StepHandler:
+		logger.DebugContext(ctx, "Run handler")
+	case StepFinal:
+		logger.DebugContext(ctx, "Run final transform")
+	}
+
+	err := next(context.WithValue(ctx, loggerKey{}, logger))
+
+	switch {
+	case errors.Is(err, ErrLimited):
+		logger.DebugContext(ctx, "Execution deferred", slog.Any("error", err))
+
+	case err != nil && step.Kind == StepExecute:
+		logger.ErrorContext(ctx, "Execution failed", slog.Any("error", err))
+
+	case err != nil:
+		logger.DebugContext(ctx, "Step failed", slog.Any("error", err))
+
+	case step.Kind == StepTransition:
+		if result, ok := step.Result(); ok {
+			logger.InfoContext(ctx, "Transition applied",
+				slog.String("next_state", result.StateName),
+				slog.Int("next_version", result.Version))
+		}
+	}
+
+	return err
+}
+
+// loggerOrDefault returns the logger of the Automata, or slog.Default if no logger is configured.
+func (a *Automata[TxContext, R]) loggerOrDefault() *slog.Logger {
+	if a.logger == nil {
+		return slog.Default()
+	}
+
+	return a.logger
+}
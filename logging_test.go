@@ -0,0 +1,117 @@
+package pee
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	type StateA struct {
+		State `name:"A"`
+		Fail  bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	var buffer *bytes.Buffer
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	logRecords := func() []map[string]any {
+		var records []map[string]any
+
+		dec := json.NewDecoder(buffer)
+		for dec.More() {
+			var record map[string]any
+			Expect(dec.Decode(&record)).To(Succeed())
+
+			delete(record, "time")
+			records = append(records, record)
+		}
+
+		return records
+	}
+
+	BeforeEach(func() {
+		buffer = &bytes.Buffer{}
+
+		a = New[string](NewMemoryStore())
+		a.WithLogger(slog.New(slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			Logger(ctx).Info("Hello from handler")
+
+			if state.Fail {
+				return nil, errors.New("failed")
+			}
+
+			return a.NewTransition(StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+	})
+
+	It("logs transitions and provides a logger to handlers", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(logRecords()).To(Equal([]map[string]any{
+			{
+				"level":       "INFO",
+				"msg":         "Hello from handler",
+				"instance_id": "1",
+				"version":     1.0,
+				"state":       "A",
+				"step":        "handler",
+			},
+			{
+				"level":        "INFO",
+				"msg":          "Transition applied",
+				"instance_id":  "1",
+				"version":      1.0,
+				"state":        "A",
+				"step":         "transition",
+				"next_state":   "B",
+				"next_version": 2.0,
+			},
+		}))
+	})
+
+	It("logs failed executions", func() {
+		instance, err := a.Start(ctx, StateA{Fail: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(HaveOccurred())
+
+		records := logRecords()
+		Expect(records).To(HaveLen(2))
+		Expect(records[1]).To(Equal(map[string]any{
+			"level":       "ERROR",
+			"msg":         "Execution failed",
+			"instance_id": "1",
+			"version":     1.0,
+			"state":       "A",
+			"step":        "execute",
+			"error":       `handler failed (instance=1, version=1, state="A"): failed`,
+		}))
+	})
+
+	It("returns the default logger outside of an execution", func() {
+		Expect(Logger(ctx)).To(BeIdenticalTo(slog.Default()))
+	})
+})
@@ -0,0 +1,53 @@
+package pee_prometheus
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// CountFunc counts the instances per state, see pee.Automata.CountByState.
+type CountFunc func(ctx context.Context) (map[string]int, error)
+
+// ScrapeTimeout is the maximum time a CountFunc may take during a scrape.
+var ScrapeTimeout = 10 * time.Second
+
+type instanceCollector struct {
+	desc  *prometheus.Desc
+	count CountFunc
+}
+
+// RegisterInstanceCounts registers a gauge with the number of instances per state
+// for the automata with the given name. The CountFunc is called on every scrape and
+// will typically run pee.Automata.CountByState in a new transaction.
+// Failures of the CountFunc are reported to the registry as invalid metric.
+func RegisterInstanceCounts(registry prometheus.Registerer, automata string, count CountFunc) error {
+	collector := &instanceCollector{
+		desc: prometheus.NewDesc("pee_instances",
+			"Number of instances per state.",
+			[]string{"state"}, prometheus.Labels{"automata": automata}),
+
+		count: count,
+	}
+
+	return registry.Register(collector)
+}
+
+func (c *instanceCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.desc
+}
+
+func (c *instanceCollector) Collect(metrics chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), ScrapeTimeout)
+	defer cancel()
+
+	counts, err := c.count(ctx)
+	if err != nil {
+		metrics <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for state, count := range counts {
+		metrics <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), state)
+	}
+}
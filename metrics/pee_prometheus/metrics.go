@@ -0,0 +1,139 @@
+package pee_prometheus
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"pee"
+	"time"
+)
+
+// Metrics collects prometheus metrics for the execution of an Automata.
+// Use Instrument to attach the metrics to an Automata.
+//
+// The counter of optimistic locking conflicts is updated by the OnError hook. It only
+// counts conflicts that fail an execution. Conflicts resolved by a retry of runInTx or
+// ignored by the Store, e.g. when recording a failure, are not visible to the hooks.
+type Metrics struct {
+	transitions     *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	errors          *prometheus.CounterVec
+	conflicts       prometheus.Counter
+}
+
+// New creates the metrics for the automata with the given name and registers them
+// with the given registry. The name is added as "automata" label to all metrics.
+func New(registry prometheus.Registerer, automata string) (*Metrics, error) {
+	labels := prometheus.Labels{"automata": automata}
+
+	m := &Metrics{
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "pee_transitions_total",
+			Help:        "Number of transitions between two states.",
+			ConstLabels: labels,
+		}, []string{"from", "to"}),
+
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "pee_handler_duration_seconds",
+			Help:        "Time spent in the handler of a state.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"state"}),
+
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "pee_errors_total",
+			Help:        "Number of failed executions by state and class of the error.",
+			ConstLabels: labels,
+		}, []string{"state", "class"}),
+
+		conflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "pee_optimistic_locking_conflicts_total",
+			Help:        "Number of executions that failed due to a concurrent update of the instance.",
+			ConstLabels: labels,
+		}),
+	}
+
+	collectors := []prometheus.Collector{m.transitions, m.handlerDuration, m.errors, m.conflicts}
+
+	for _, collector := range collectors {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Instrument attaches the metrics to the given Automata.
+func Instrument[TxContext context.Context, R any](a *pee.Automata[TxContext, R], m *Metrics) {
+	a.Use(m.Middleware()).WithHooks(m.Hooks())
+}
+
+// Middleware returns a pee.Middleware that measures the time spent in handlers.
+func (m *Metrics) Middleware() pee.Middleware {
+	return func(ctx context.Context, step pee.Step, next func(ctx context.Context) error) error {
+		if step.Kind != pee.StepHandler {
+			return next(ctx)
+		}
+
+		startTime := time.Now()
+		defer func() {
+			m.handlerDuration.WithLabelValues(step.Instance.StateName).Observe(time.Since(startTime).Seconds())
+		}()
+
+		return next(ctx)
+	}
+}
+
+// Hooks returns pee.Hooks that count transitions and errors. Errors caused by
+// optimistic locking are counted as conflicts, too.
+func (m *Metrics) Hooks() pee.Hooks {
+	return pee.Hooks{
+		AfterTransition: func(ctx context.Context, from, to pee.Instance) {
+			m.transitions.WithLabelValues(from.StateName, to.StateName).Inc()
+		},
+
+		OnError: func(ctx context.Context, instance pee.Instance, err error) {
+			class := ErrorClass(err)
+			if class == "optimistic_locking" {
+				m.conflicts.Inc()
+			}
+
+			m.errors.WithLabelValues(instance.StateName, class).Inc()
+		},
+	}
+}
+
+// ErrorClass returns the class of the error used as label of the error counter.
+func ErrorClass(err error) string {
+	var handlerErr *pee.HandlerError
+	var transitionErr *pee.TransitionError
+	var storeErr *pee.StoreError
+	var serializationErr *pee.SerializationError
+
+	switch {
+	case errors.Is(err, pee.ErrOptimisticLocking):
+		return "optimistic_locking"
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+
+	case errors.As(err, &handlerErr):
+		return "handler"
+
+	case errors.As(err, &transitionErr):
+		return "transition"
+
+	case errors.As(err, &storeErr):
+		return "store"
+
+	case errors.As(err, &serializationErr):
+		return "serialization"
+
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,122 @@
+package pee_prometheus
+
+import (
+	"context"
+	"errors"
+	"github.com/onsi/ginkgo/v2/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"pee"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRunSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Prometheus metrics specs", types.ReporterConfig{Verbose: true})
+}
+
+var _ = Describe("Metrics", func() {
+	type StateA struct {
+		pee.State `name:"A"`
+		Fail      bool
+	}
+
+	type StateB struct {
+		pee.State `name:"B"`
+	}
+
+	var registry *prometheus.Registry
+	var a *pee.Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+
+		a = pee.New[string](pee.NewMemoryStore())
+
+		pee.AddState(a, func(ctx context.Context, state StateA) (*pee.StateTransition[context.Context], error) {
+			if state.Fail {
+				return nil, errors.New("failed")
+			}
+
+			return a.NewTransition(StateB{}).AsTuple()
+		})
+
+		pee.AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		metrics, err := New(registry, "test")
+		Expect(err).ToNot(HaveOccurred())
+
+		Instrument(a, metrics)
+	})
+
+	It("counts transitions, errors and handler invocations", func() {
+		for _, fail := range []bool{false, false, true} {
+			instance, err := a.Start(ctx, StateA{Fail: fail})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, _ = a.Execute(ctx, pee.DummyRunInTx, instance)
+		}
+
+		expected := `
+			# HELP pee_errors_total Number of failed executions by state and class of the error.
+			# TYPE pee_errors_total counter
+			pee_errors_total{automata="test",class="handler",state="A"} 1
+			# HELP pee_transitions_total Number of transitions between two states.
+			# TYPE pee_transitions_total counter
+			pee_transitions_total{automata="test",from="A",to="B"} 2
+		`
+
+		Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected),
+			"pee_errors_total", "pee_transitions_total")).To(Succeed())
+
+		Expect(testutil.CollectAndCount(registry, "pee_handler_duration_seconds")).To(Equal(1))
+	})
+
+	It("counts optimistic locking conflicts", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, pee.DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, pee.DummyRunInTx, instance)
+		Expect(err).To(MatchError(pee.ErrOptimisticLocking))
+
+		expected := `
+			# HELP pee_optimistic_locking_conflicts_total Number of executions that failed due to a concurrent update of the instance.
+			# TYPE pee_optimistic_locking_conflicts_total counter
+			pee_optimistic_locking_conflicts_total{automata="test"} 1
+		`
+
+		Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected), "pee_optimistic_locking_conflicts_total")).To(Succeed())
+	})
+
+	It("reports the number of instances per state", func() {
+		err := RegisterInstanceCounts(registry, "test", func(ctx context.Context) (map[string]int, error) {
+			return a.CountByState(ctx)
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+
+		for idx := 0; idx < 3; idx++ {
+			_, err := a.Start(ctx, StateA{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		expected := `
+			# HELP pee_instances Number of instances per state.
+			# TYPE pee_instances gauge
+			pee_instances{automata="test",state="A"} 3
+		`
+
+		Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected), "pee_instances")).To(Succeed())
+	})
+})
@@ -0,0 +1,124 @@
+package pee
+
+import (
+	"context"
+)
+
+// StepKind describes which part of a step is wrapped by a Middleware.
+type StepKind int
+
+const (
+	// StepHandler is the invocation of the Handler of the current State.
+	StepHandler StepKind = iota
+
+	// StepTransition is the application of a StateTransition, including the update
+	// of the Instance in the Store. It runs within the transaction started by RunInTx.
+	StepTransition
+
+	// StepFinal is the invocation of the Transform of a final State.
+	StepFinal
+
+	// StepExecute is a complete execution of an Instance by Automata.Execute. All other
+	// steps of the execution are run within a StepExecute.
+	StepExecute
+)
+
+func (k StepKind) String() string {
+	switch k {
+	case StepHandler:
+		return "handler"
+	case StepTransition:
+		return "transition"
+	case StepFinal:
+		return "final"
+	case StepExecute:
+		return "execute"
+	default:
+		return "unknown"
+	}
+}
+
+// Step describes the part of the execution of an Instance that is wrapped by a Middleware.
+type Step struct {
+	Kind StepKind
+
+	// Instance is the Instance before executing the step.
+	Instance Instance
+
+	// the new instance after a transition
+	result *Instance
+}
+
+// Result returns the new Instance after a StepTransition. The result is only available
+// after the next function of a Middleware returned without an error.
+func (s Step) Result() (Instance, bool) {
+	if s.result == nil || s.result.State == nil {
+		return Instance{}, false
+	}
+
+	return *s.result, true
+}
+
+// Middleware wraps the execution of a Step. The Middleware must call next to continue
+// the execution and should return the error returned by next.
+// The context passed to next is used for the remaining execution of the step, this allows
+// a Middleware to attach values to the context. For a StepTransition, the context passed to the
+// Middleware is the TxContext of the transaction. A Middleware should derive the new context
+// from the given one, otherwise the values of the new context might not be visible to actions.
+type Middleware func(ctx context.Context, step Step, next func(ctx context.Context) error) error
+
+// Use adds the given Middleware to the Automata. Middlewares are called in the
+// order they were added, the first Middleware is the outermost one.
+func (a *Automata[TxContext, R]) Use(middlewares ...Middleware) *Automata[TxContext, R] {
+	a.middlewares = append(a.middlewares, middlewares...)
+	return a
+}
+
+// around runs the given function wrapped by all middlewares of the Automata.
+// If the Automata has a logger, logging is the outermost middleware.
+func (a *Automata[TxContext, R]) around(ctx context.Context, step Step, fn func(ctx context.Context) error) error {
+	ctx = withInstance(ctx, step.Instance)
+
+	middlewares := a.middlewares
+	if a.logger != nil {
+		middlewares = append([]Middleware{a.logStep}, middlewares...)
+	}
+
+	next := fn
+
+	if a.panicRecovery != nil {
+		next = recoverPanic(step, next)
+	}
+
+	for idx := len(middlewares) - 1; idx >= 0; idx-- {
+		middleware, inner := middlewares[idx], next
+
+		next = func(ctx context.Context) error {
+			return middleware(ctx, step, inner)
+		}
+
+		if a.panicRecovery != nil {
+			next = recoverPanic(step, next)
+		}
+	}
+
+	return next(ctx)
+}
+
+// withContext returns a TxContext that uses the given context. This is only possible
+// if the context already is a TxContext or if the TxContext supports
+// replacing its context with a `WithContext` method like the ql.TxContext does.
+// Otherwise the original TxContext is returned.
+func withContext[TxContext context.Context](tx TxContext, ctx context.Context) TxContext {
+	if txCtx, ok := ctx.(TxContext); ok {
+		return txCtx
+	}
+
+	if withCtx, ok := any(tx).(interface {
+		WithContext(ctx context.Context) TxContext
+	}); ok {
+		return withCtx.WithContext(ctx)
+	}
+
+	return tx
+}
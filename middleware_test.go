@@ -0,0 +1,119 @@
+package pee
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Middleware and hooks", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type ctxKey struct{}
+
+	var a *Automata[context.Context, string]
+	var failHandler bool
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		failHandler = false
+
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			if failHandler {
+				return nil, errors.New("handler failed")
+			}
+
+			return a.NewTransition(StateB{}).
+				WithAction(func(ctx context.Context) error {
+					// values of the middleware are visible within actions
+					Expect(ctx.Value(ctxKey{})).To(Equal("transition"))
+					return nil
+				}).
+				AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return ctx.Value(ctxKey{}).(string), nil
+		})
+	})
+
+	It("wraps every step with the middlewares in order", func() {
+		var calls []string
+
+		record := func(name string) Middleware {
+			return func(ctx context.Context, step Step, next func(ctx context.Context) error) error {
+				calls = append(calls, name+":"+step.Kind.String()+":"+step.Instance.StateName)
+				return next(context.WithValue(ctx, ctxKey{}, step.Kind.String()))
+			}
+		}
+
+		a.Use(record("outer"), record("inner"))
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("final"))
+
+		Expect(calls).To(Equal([]string{
+			"outer:execute:A", "inner:execute:A",
+			"outer:handler:A", "inner:handler:A",
+			"outer:transition:A", "inner:transition:A",
+			"outer:final:B", "inner:final:B",
+		}))
+	})
+
+	It("calls the hooks", func() {
+		var calls []string
+
+		a.Use(func(ctx context.Context, step Step, next func(ctx context.Context) error) error {
+			return next(context.WithValue(ctx, ctxKey{}, step.Kind.String()))
+		})
+
+		a.WithHooks(Hooks{
+			BeforeStep: func(ctx context.Context, instance Instance) {
+				calls = append(calls, "before:"+instance.StateName)
+			},
+			AfterTransition: func(ctx context.Context, from, to Instance) {
+				calls = append(calls, "transition:"+from.StateName+"->"+to.StateName)
+			},
+			OnError: func(ctx context.Context, instance Instance, err error) {
+				calls = append(calls, "error:"+instance.StateName+":"+err.Error())
+			},
+			OnFinal: func(ctx context.Context, instance Instance) {
+				calls = append(calls, "final:"+instance.StateName)
+			},
+		})
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(calls).To(Equal([]string{"before:A", "transition:A->B", "before:B", "final:B"}))
+
+		calls = nil
+		failHandler = true
+
+		instance, err = a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(HaveOccurred())
+
+		Expect(calls).To(Equal([]string{"before:A", `error:A:handler failed (instance=2, version=1, state="A"): handler failed`}))
+	})
+})
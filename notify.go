@@ -0,0 +1,58 @@
+package pee
+
+import (
+	"sync"
+)
+
+// Notifier notifies about changed instances, e.g. using Postgres LISTEN/NOTIFY.
+// Notifications are only hints, a subscriber might miss notifications
+// and should fall back to polling the Store.
+type Notifier interface {
+	// Subscribe returns a channel that receives the id of an instance whenever the
+	// instance changed. The subscription ends when the returned function is called.
+	Subscribe() (<-chan InstanceId, func())
+}
+
+// Broadcaster is a Notifier that delivers every published id to all current subscribers.
+// If a subscriber does not keep up with the notifications, further notifications
+// for this subscriber are dropped.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan InstanceId]struct{}
+}
+
+var _ Notifier = &Broadcaster{}
+
+// NewBroadcaster creates a new Broadcaster without any subscribers.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: map[chan InstanceId]struct{}{}}
+}
+
+// Publish notifies all subscribers about a change of the instance with the given id.
+func (b *Broadcaster) Publish(id InstanceId) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- id:
+		default:
+		}
+	}
+}
+
+func (b *Broadcaster) Subscribe() (<-chan InstanceId, func()) {
+	subscriber := make(chan InstanceId, 16)
+
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		delete(b.subscribers, subscriber)
+		b.mu.Unlock()
+	}
+
+	return subscriber, unsubscribe
+}
@@ -0,0 +1,154 @@
+package pee
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// DefaultGroup is the default name of the group of runners sharing the partitions of an Automata.
+const DefaultGroup = "default"
+
+// WithPartitions assigns every new Instance to one of count partitions. Runners of the same
+// group share the partitions, so that each Runner only polls the instances in the partitions
+// it claimed, see RunnerConfig.Group. The Store must implement PartitionStore.
+//
+// An Instance is assigned by the hash of the value of the given label, or by the hash of its
+// id, if the label is empty or the Instance does not have the label. If the Store assigns the
+// ids, instances without the label are assigned to a random partition.
+// Instances started before the Automata was partitioned are in partition 0. The number of
+// partitions must not be changed after instances were started.
+func (a *Automata[TxContext, R]) WithPartitions(count int, label string) *Automata[TxContext, R] {
+	a.partitions = count
+	a.partitionLabel = label
+	return a
+}
+
+// partitionOf returns the partition of a new instance with the given id and labels.
+func (a *Automata[TxContext, R]) partitionOf(id InstanceId, labels map[string]string) int {
+	if a.partitions <= 0 {
+		return 0
+	}
+
+	key := string(id)
+	if value := labels[a.partitionLabel]; a.partitionLabel != "" && value != "" {
+		key = value
+	}
+
+	if key == "" {
+		return rand.Intn(a.partitions)
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	return int(hash.Sum32() % uint32(a.partitions))
+}
+
+// assignPartitions returns the partitions assigned to the worker, if the partitions
+// are distributed round robin to the given members. The members must be sorted, so
+// that all members compute the same assignment.
+func assignPartitions(count int, members []string, worker string) []int {
+	index := -1
+	for idx, member := range members {
+		if member == worker {
+			index = idx
+		}
+	}
+
+	if index < 0 {
+		return nil
+	}
+
+	var assigned []int
+	for partition := index; partition < count; partition += len(members) {
+		assigned = append(assigned, partition)
+	}
+
+	return assigned
+}
+
+// claimPartitions renews the membership of the runner in its group and claims the
+// partitions assigned to it. Partitions that are now assigned to another member are
+// released, so the other member can claim them. Returns the claimed partitions.
+//
+// While the members of the group change, a partition might not be claimed by
+// any runner until the claim of its previous owner expires.
+func (r *Runner[TxContext, R]) claimPartitions(ctx context.Context) ([]int, error) {
+	store, ok := r.automata.store.(PartitionStore[TxContext])
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var claimed []int
+
+	_, err := r.runInTx(ctx, func(tx TxContext) (Instance, error) {
+		expiresAt := time.Now().Add(r.config.Lease)
+
+		members, err := store.Heartbeat(tx, r.config.Group, r.config.WorkerId, expiresAt)
+		if err != nil {
+			return Instance{}, err
+		}
+
+		assigned := assignPartitions(r.automata.partitions, members, r.config.WorkerId)
+
+		for _, partition := range r.claimed {
+			if !contains(assigned, partition) {
+				if err := store.ReleasePartition(tx, r.config.Group, partition, r.config.WorkerId); err != nil {
+					return Instance{}, err
+				}
+			}
+		}
+
+		claimed = nil
+
+		for _, partition := range assigned {
+			ok, err := store.ClaimPartition(tx, r.config.Group, partition, r.config.WorkerId, expiresAt)
+			if err != nil {
+				return Instance{}, err
+			}
+
+			if ok {
+				claimed = append(claimed, partition)
+			}
+		}
+
+		return Instance{}, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.claimed = claimed
+
+	return claimed, nil
+}
+
+// leave removes the runner from its group and releases its partitions, so
+// the remaining members can claim them right away.
+func (r *Runner[TxContext, R]) leave(ctx context.Context) {
+	store, ok := r.automata.store.(PartitionStore[TxContext])
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.runInTx(ctx, func(tx TxContext) (Instance, error) {
+		return Instance{}, store.Leave(tx, r.config.Group, r.config.WorkerId)
+	})
+
+	if err != nil {
+		r.automata.loggerOrDefault().WarnContext(ctx, "Leaving group failed",
+			slog.String("group", r.config.Group), slog.Any("error", err))
+	}
+
+	r.claimed = nil
+}
@@ -0,0 +1,127 @@
+package pee
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Partitions", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore()).WithPartitions(2, "tenant")
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+	})
+
+	// startInstances starts instances for multiple tenants and returns
+	// the number of instances per partition.
+	startInstances := func() map[int]int {
+		counts := map[int]int{}
+
+		for idx := 0; idx < 10; idx++ {
+			instance, err := a.Start(ctx, StateA{}, WithLabel("tenant", fmt.Sprintf("tenant-%d", idx)))
+			Expect(err).ToNot(HaveOccurred())
+
+			counts[instance.Partition]++
+		}
+
+		Expect(counts).To(HaveLen(2))
+
+		return counts
+	}
+
+	It("assigns instances to partitions by label", func() {
+		first, err := a.Start(ctx, StateA{}, WithLabel("tenant", "acme"))
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := a.Start(ctx, StateA{}, WithLabel("tenant", "acme"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(first.Partition).To(Equal(second.Partition))
+
+		loaded, err := a.Load(ctx, first.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.Partition).To(Equal(first.Partition))
+
+		for idx := 0; idx < 10; idx++ {
+			instance, err := a.Start(ctx, StateA{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Partition).To(BeNumerically("<", 2))
+		}
+	})
+
+	It("assigns partitions round robin to the members", func() {
+		members := []string{"a", "b"}
+
+		Expect(assignPartitions(5, members, "a")).To(Equal([]int{0, 2, 4}))
+		Expect(assignPartitions(5, members, "b")).To(Equal([]int{1, 3}))
+		Expect(assignPartitions(5, members, "c")).To(BeEmpty())
+	})
+
+	It("shares the partitions between the runners of a group", func() {
+		first := NewRunner(a, DummyRunInTx, RunnerConfig{WorkerId: "a"})
+		second := NewRunner(a, DummyRunInTx, RunnerConfig{WorkerId: "b"})
+
+		// the first runner claims all partitions, until the second one joins
+		Expect(first.Poll(ctx)).To(Equal(0))
+		Expect(second.Poll(ctx)).To(Equal(0))
+		Expect(first.Poll(ctx)).To(Equal(0))
+
+		counts := startInstances()
+
+		Expect(first.Poll(ctx)).To(Equal(counts[0]))
+		Expect(second.Poll(ctx)).To(Equal(counts[1]))
+	})
+
+	It("rebalances the partitions when a runner leaves", func() {
+		first := NewRunner(a, DummyRunInTx, RunnerConfig{WorkerId: "a"})
+		second := NewRunner(a, DummyRunInTx, RunnerConfig{WorkerId: "b"})
+
+		Expect(first.Poll(ctx)).To(Equal(0))
+		Expect(second.Poll(ctx)).To(Equal(0))
+		Expect(first.Poll(ctx)).To(Equal(0))
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		Expect(second.Run(canceled)).To(MatchError(context.Canceled))
+
+		startInstances()
+
+		Expect(first.Poll(ctx)).To(Equal(10))
+	})
+
+	It("takes over the partitions of runners whose membership expired", func() {
+		first := NewRunner(a, DummyRunInTx, RunnerConfig{WorkerId: "a"})
+		second := NewRunner(a, DummyRunInTx, RunnerConfig{WorkerId: "b", Lease: 20 * time.Millisecond})
+
+		Expect(second.Poll(ctx)).To(Equal(0))
+
+		startInstances()
+
+		Eventually(func() (int, error) {
+			return first.Poll(ctx)
+		}).Should(Equal(10))
+	})
+})
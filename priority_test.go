@@ -0,0 +1,119 @@
+package pee
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Priorities", func() {
+	type StateA struct {
+		State    `name:"A"`
+		Name     string
+		Escalate bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	var a *Automata[context.Context, string]
+	var executed []string
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		executed = nil
+
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			executed = append(executed, state.Name)
+
+			if state.Escalate {
+				return Transition[context.Context](StateA{Name: state.Name}).WithPriority(10).AsTuple()
+			}
+
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+	})
+
+	names := func(instances []Instance) []string {
+		var names []string
+		for _, instance := range instances {
+			names = append(names, instance.State.(StateA).Name)
+		}
+
+		return names
+	}
+
+	It("lists instances with higher priorities first", func() {
+		_, err := a.Start(ctx, StateA{Name: "low"}, WithPriority(-1))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Start(ctx, StateA{Name: "default"})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Start(ctx, StateA{Name: "high"}, WithPriority(5))
+		Expect(err).ToNot(HaveOccurred())
+
+		instances, err := a.List(ctx, ListQuery{Order: OrderByPriority})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(instances)).To(Equal([]string{"high", "default", "low"}))
+
+		instances, err = a.List(ctx, ListQuery{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(instances)).To(Equal([]string{"low", "default", "high"}))
+	})
+
+	It("changes the priority with a transition", func() {
+		instance, err := a.Start(ctx, StateA{Name: "escalated", Escalate: true}, WithPriority(1))
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = a.Step(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.Priority).To(Equal(10))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.Priority).To(Equal(10))
+	})
+
+	It("interleaves the values of the fairness label", func() {
+		for _, name := range []string{"a1", "a2", "a3"} {
+			_, err := a.Start(ctx, StateA{Name: name}, WithLabel("tenant", "a"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		for _, name := range []string{"b1", "b2"} {
+			_, err := a.Start(ctx, StateA{Name: name}, WithLabel("tenant", "b"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		instances, err := a.List(ctx, ListQuery{FairnessLabel: "tenant", Limit: 4})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(instances)).To(Equal([]string{"a1", "b1", "a2", "b2"}))
+	})
+
+	It("reserves a share of the runners batch for the oldest instances", func() {
+		_, err := a.Start(ctx, StateA{Name: "oldest"})
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, name := range []string{"high1", "high2", "high3", "high4"} {
+			_, err := a.Start(ctx, StateA{Name: name}, WithPriority(5))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		runner := NewRunner(a, DummyRunInTx, RunnerConfig{BatchSize: 4})
+
+		count, err := runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(4))
+		Expect(executed).To(Equal([]string{"high1", "high2", "high3", "oldest"}))
+	})
+})
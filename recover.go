@@ -0,0 +1,63 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+)
+
+// FailureState returns the State an Instance is moved to after a panic.
+type FailureState func(err *PanicError) State
+
+type panicRecovery struct {
+	failureState FailureState
+}
+
+// WithPanicRecovery enables recovery of panics in handlers, transforms, actions and middlewares.
+// A recovered panic is returned as a PanicError including the stack trace.
+//
+// If a FailureState is provided, an Instance that panicked in a handler or an action is
+// moved into the State returned by the FailureState and the execution continues in that State.
+// The failure State must be registered with the Automata and should usually be a final State.
+func (a *Automata[TxContext, R]) WithPanicRecovery(failureState FailureState) *Automata[TxContext, R] {
+	a.panicRecovery = &panicRecovery{failureState: failureState}
+	return a
+}
+
+// recoverPanic wraps the given function to recover a panic into a PanicError.
+func recoverPanic(step Step, fn func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) (err error) {
+		defer func() {
+			if value := recover(); value != nil {
+				err = &PanicError{
+					ErrorContext: errorContextOf(step.Instance),
+					Value:        value,
+					Stack:        debug.Stack(),
+				}
+			}
+		}()
+
+		return fn(ctx)
+	}
+}
+
+// failureTransition returns a transition into the failure state, if the error
+// is a PanicError and a failure state is configured. An instance that is already
+// in the failure state is not moved again, to not loop forever.
+func (a *Automata[TxContext, R]) failureTransition(instance Instance, err error) (*StateTransition[TxContext], bool) {
+	if a.panicRecovery == nil || a.panicRecovery.failureState == nil {
+		return nil, false
+	}
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		return nil, false
+	}
+
+	failureState := a.panicRecovery.failureState(panicErr)
+	if NameOf(failureState) == instance.StateName {
+		return nil, false
+	}
+
+	return Transition[TxContext](failureState), true
+}
@@ -0,0 +1,110 @@
+package pee
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Panic recovery", func() {
+	type StateA struct {
+		State       `name:"A"`
+		PanicAction bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateFailed struct {
+		State `name:"Failed"`
+		Panic string
+	}
+
+	errExploded := errors.New("action exploded")
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			if !state.PanicAction {
+				panic("handler exploded")
+			}
+
+			return a.NewTransition(StateB{}).
+				WithInfallibleAction(func(ctx context.Context) { panic(errExploded) }).
+				AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateFailed) (string, error) {
+			return "failed: " + state.Panic, nil
+		})
+	})
+
+	It("converts a panic into a PanicError", func() {
+		a.WithPanicRecovery(nil)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+
+		var panicErr *PanicError
+		Expect(errors.As(err, &panicErr)).To(BeTrue())
+		Expect(panicErr.Value).To(Equal("handler exploded"))
+		Expect(panicErr.StateName).To(Equal("A"))
+		Expect(string(panicErr.Stack)).To(ContainSubstring("recover_test.go"))
+		Expect(IsRetryable(err)).To(BeFalse())
+	})
+
+	It("unwraps errors passed to panic", func() {
+		a.WithPanicRecovery(nil)
+
+		instance, err := a.Start(ctx, StateA{PanicAction: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(errExploded))
+
+		// the instance was not updated
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("A"))
+	})
+
+	It("moves the instance into the failure state", func() {
+		a.WithPanicRecovery(func(err *PanicError) State {
+			return StateFailed{Panic: err.Error()}
+		})
+
+		for _, panicAction := range []bool{false, true} {
+			instance, err := a.Start(ctx, StateA{PanicAction: panicAction})
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(HavePrefix("failed: panic"))
+
+			instance, err = a.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.StateName).To(Equal("Failed"))
+		}
+	})
+
+	It("does not recover panics by default", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(func() { _, _ = a.Execute(ctx, DummyRunInTx, instance) }).To(PanicWith("handler exploded"))
+	})
+})
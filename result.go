@@ -0,0 +1,98 @@
+package pee
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// WithResultPersistence configures the Automata to persist the result of the final states
+// Transform the first time an Instance reaches its final State. Later calls of Execute or
+// Await return the persisted result without calling the Transform again.
+// Results are serialized to json. The Store must implement ResultStore.
+func (a *Automata[TxContext, R]) WithResultPersistence() *Automata[TxContext, R] {
+	a.persistResults = true
+	return a
+}
+
+// finalResult returns the persisted result of the instance, or runs the
+// final transform and persists its result, if result persistence is enabled.
+func (a *Automata[TxContext, R]) finalResult(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance, final Transform[State, R]) (R, error) {
+	var result R
+
+	if a.persistResults {
+		result, ok, err := a.persistedResult(ctx, runInTx, instance)
+		if err != nil || ok {
+			return result, err
+		}
+	}
+
+	err := a.around(ctx, Step{Kind: StepFinal, Instance: *instance}, func(ctx context.Context) (err error) {
+		result, err = final(ctx, instance.State)
+		return err
+	})
+
+	if err != nil {
+		return result, &HandlerError{ErrorContext: errorContextOf(*instance), Err: err}
+	}
+
+	if a.persistResults {
+		if err := a.persistResult(ctx, runInTx, instance, result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// persistedResult returns the persisted result of the instance and true, if a result was persisted.
+// The instance is reloaded if it has no result, as the result might have been persisted by another execution.
+func (a *Automata[TxContext, R]) persistedResult(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance) (R, bool, error) {
+	var result R
+
+	if instance.result == nil {
+		loaded, err := runInTx(ctx, func(tx TxContext) (Instance, error) {
+			return a.Load(tx, instance.Id)
+		})
+
+		if err != nil {
+			return result, false, err
+		}
+
+		instance.result = loaded.result
+	}
+
+	if instance.result == nil {
+		return result, false, nil
+	}
+
+	if err := json.Unmarshal(instance.result, &result); err != nil {
+		return result, false, &SerializationError{ErrorContext: errorContextOf(*instance), Err: err}
+	}
+
+	return result, true, nil
+}
+
+// persistResult serializes the result and persists it with the instance.
+func (a *Automata[TxContext, R]) persistResult(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance, result R) error {
+	store, ok := a.store.(ResultStore[TxContext])
+	if !ok {
+		return &StoreError{ErrorContext: errorContextOf(*instance), Op: "store result", Err: ErrNotSupported}
+	}
+
+	serialized, err := json.Marshal(result)
+	if err != nil {
+		return &SerializationError{ErrorContext: errorContextOf(*instance), Err: err}
+	}
+
+	_, err = runInTx(ctx, func(tx TxContext) (Instance, error) {
+		return Instance{}, store.StoreResult(tx, instance.Id, instance.Version, serialized)
+	})
+
+	if err != nil {
+		return &StoreError{ErrorContext: errorContextOf(*instance), Op: "store result", Err: err}
+	}
+
+	instance.result = serialized
+
+	return nil
+}
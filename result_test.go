@@ -0,0 +1,79 @@
+package pee
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Result persistence", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type Result struct {
+		Value int
+	}
+
+	var a *Automata[context.Context, Result]
+	var transforms int
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		transforms = 0
+
+		a = New[Result](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (Result, error) {
+			transforms++
+			return Result{Value: transforms}, nil
+		})
+	})
+
+	It("calls the transform on every execution by default", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = a.ExecuteN(ctx, DummyRunInTx, instance, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		for value := 1; value <= 2; value++ {
+			result, err := a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Value).To(Equal(value))
+		}
+	})
+
+	It("returns the persisted result", func() {
+		a.WithResultPersistence()
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = a.ExecuteN(ctx, DummyRunInTx, instance, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		for range []int{1, 2} {
+			// the instance does not know about the persisted result, it is loaded from the store
+			result, err := a.Execute(ctx, DummyRunInTx, instance)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Value).To(Equal(1))
+		}
+
+		result, err := a.Await(ctx, DummyRunInTx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Value).To(Equal(1))
+
+		Expect(transforms).To(Equal(1))
+	})
+})
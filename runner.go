@@ -0,0 +1,327 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is the default time between two polls of a Runner.
+const DefaultPollInterval = 5 * time.Second
+
+// DefaultMinPollInterval is the default minimum time between two polls of a Runner triggered by notifications.
+const DefaultMinPollInterval = time.Second
+
+// DefaultBatchSize is the default number of instances a Runner executes per poll.
+const DefaultBatchSize = 100
+
+// RunnerConfig configures a Runner.
+type RunnerConfig struct {
+	// PollInterval is the time between two polls for runnable instances.
+	// Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// MinPollInterval is the minimum time between two polls, if a poll is triggered by a notification.
+	// Notifications within this time are collected and handled by a single poll. This also covers the
+	// notifications about the changes of the Runner itself, e.g. failed attempts, which would otherwise
+	// retry failing instances back to back. Defaults to DefaultMinPollInterval, but not more than the PollInterval.
+	MinPollInterval time.Duration
+
+	// BatchSize is the maximum number of instances executed per poll.
+	// Defaults to DefaultBatchSize.
+	BatchSize int
+
+	// FairnessLabel is the name of a label, e.g. "tenant", to share the executions of a poll
+	// fairly between the values of the label. See ListQuery.FairnessLabel.
+	FairnessLabel string
+
+	// BatchSteps executes the instances of a poll together: the next transition of all
+	// instances is applied in a single transaction, see Automata.StepMany. This reduces the
+	// number of round trips to the Store, but a failing transaction is retried per Instance.
+	BatchSteps bool
+
+	// Retention is the time completed instances are kept in the Store after reaching
+	// a final State. Completed instances are kept forever if zero.
+	// Purging completed instances requires a Store that implements PurgeStore.
+	Retention time.Duration
+
+	// Group is the name of the group of runners that share the partitions of a partitioned
+	// Automata, see Automata.WithPartitions. Defaults to DefaultGroup.
+	Group string
+
+	// WorkerId identifies the Runner within its Group. Defaults to a random id.
+	WorkerId string
+
+	// Lease is the time the membership of the Runner in its Group and its claims on
+	// partitions are valid without being renewed by a poll. Defaults to three times the PollInterval.
+	Lease time.Duration
+}
+
+// Runner executes the runnable instances of an Automata in the background. An Instance is
+// runnable, if it is neither in a final State nor dead lettered. This includes expired
+// instances, which are moved on by the ExpiryHandler of the Automata.
+// The Store must implement ListStore.
+//
+// Instances with a higher priority are executed first. To not starve instances with a low
+// priority, a quarter of every batch is reserved for the oldest runnable instances.
+//
+// Multiple runners can work on the same Store, concurrent executions of the same Instance
+// are prevented by optimistic locking. To reduce the contention between many runners, the
+// Automata can be partitioned, see Automata.WithPartitions. The runners of a Group then
+// register as members of the Group with every poll and distribute the partitions round robin
+// between all members. Each Runner claims its partitions and only executes the instances in
+// the claimed partitions. Partitions are rebalanced, when runners join or leave the Group.
+type Runner[TxContext context.Context, R any] struct {
+	automata *Automata[TxContext, R]
+	runInTx  RunInTx[TxContext, Instance]
+	config   RunnerConfig
+
+	mu      sync.Mutex
+	claimed []int
+}
+
+// NewRunner creates a new Runner for the given Automata. Every transition is applied
+// in a new transaction created by runInTx.
+func NewRunner[TxContext context.Context, R any](automata *Automata[TxContext, R], runInTx RunInTx[TxContext, Instance], config RunnerConfig) *Runner[TxContext, R] {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+
+	if config.MinPollInterval <= 0 {
+		config.MinPollInterval = DefaultMinPollInterval
+	}
+
+	config.MinPollInterval = min(config.MinPollInterval, config.PollInterval)
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+
+	if config.Group == "" {
+		config.Group = DefaultGroup
+	}
+
+	if config.WorkerId == "" {
+		config.WorkerId = string(UUIDGenerator())
+	}
+
+	if config.Lease <= 0 {
+		config.Lease = 3 * config.PollInterval
+	}
+
+	return &Runner[TxContext, R]{automata: automata, runInTx: runInTx, config: config}
+}
+
+// Run polls for runnable instances and executes them until the given context is done.
+// If the Automata has a Notifier, the Runner polls right away when an Instance changed, but
+// not before MinPollInterval passed since the last poll, and falls back to polling every PollInterval.
+// Failed polls are logged using the logger of the Automata. Run returns the error of the context.
+// A Runner of a partitioned Automata leaves its Group when Run returns.
+func (r *Runner[TxContext, R]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	if r.automata.partitions > 0 {
+		defer r.leave(context.WithoutCancel(ctx))
+	}
+
+	var changes <-chan InstanceId
+	if r.automata.notifier != nil {
+		var unsubscribe func()
+		changes, unsubscribe = r.automata.notifier.Subscribe()
+		defer unsubscribe()
+	}
+
+	for {
+		lastPoll := time.Now()
+
+		if _, err := r.Poll(ctx); err != nil && ctx.Err() == nil {
+			r.automata.loggerOrDefault().ErrorContext(ctx, "Poll failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+
+		case <-changes:
+			// wait for the minimum interval, the runners own changes are notified as well
+			if wait := r.config.MinPollInterval - time.Since(lastPoll); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+
+				case <-time.After(wait):
+				}
+			}
+
+			// a single poll handles all changes up to now
+			drain(changes)
+		}
+	}
+}
+
+// drain removes all pending values from the given channel.
+func drain[T any](ch <-chan T) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
+
+// Poll executes the runnable instances once and returns the number of executed instances.
+// Completed instances past the Retention are removed first. Instances in a State that
+// reached its concurrency or rate limit are deferred to a later poll. If the Automata is
+// partitioned, the Runner renews its membership and claims its partitions first.
+// Failed executions are not returned, they are reported through the logger, hooks
+// and middlewares of the Automata.
+func (r *Runner[TxContext, R]) Poll(ctx context.Context) (int, error) {
+	if r.config.Retention > 0 {
+		if err := r.purge(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	var partitions []int
+
+	if r.automata.partitions > 0 {
+		claimed, err := r.claimPartitions(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(claimed) == 0 {
+			return 0, nil
+		}
+
+		partitions = claimed
+	}
+
+	instances, err := r.runnable(ctx, partitions)
+	if err != nil {
+		return 0, err
+	}
+
+	if r.config.BatchSteps {
+		return r.executeBatched(ctx, instances), nil
+	}
+
+	var executed int
+
+	// states that reached their limit during this poll
+	limited := map[string]bool{}
+
+	for _, instance := range instances {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if limited[instance.StateName] {
+			continue
+		}
+
+		_, err := r.automata.Execute(ctx, r.runInTx, instance)
+
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			limited[limitErr.StateName] = true
+			continue
+		}
+
+		executed++
+	}
+
+	return executed, nil
+}
+
+// runnable lists the instances in the given partitions that are neither in a final state nor
+// dead lettered, highest priorities first, followed by the oldest instances.
+func (r *Runner[TxContext, R]) runnable(ctx context.Context, partitions []int) ([]Instance, error) {
+	stateNames := r.automata.runnableStateNames()
+	if len(stateNames) == 0 {
+		return nil, nil
+	}
+
+	query := ListQuery{
+		StateNames:    stateNames,
+		DeadLetter:    ExcludeDeadLetters,
+		FairnessLabel: r.config.FairnessLabel,
+		Partitions:    partitions,
+		Limit:         r.config.BatchSize,
+	}
+
+	var byPriority, oldest []Instance
+
+	_, err := r.runInTx(ctx, func(tx TxContext) (_ Instance, err error) {
+		query.Order = OrderByPriority
+		byPriority, err = r.automata.List(tx, query)
+		if err != nil {
+			return Instance{}, err
+		}
+
+		query.Order = OrderByCreation
+		query.Limit = max(1, r.config.BatchSize/4)
+		oldest, err = r.automata.List(tx, query)
+		return Instance{}, err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reserveOldest(byPriority, oldest, r.config.BatchSize), nil
+}
+
+// reserveOldest appends the oldest instances that are not yet included to the instances
+// ordered by priority. The instances ordered by priority are truncated to make room for
+// the oldest instances, so the result is not longer than the given limit.
+func reserveOldest(byPriority, oldest []Instance, limit int) []Instance {
+	included := map[InstanceId]bool{}
+	for _, instance := range byPriority {
+		included[instance.Id] = true
+	}
+
+	var reserved []Instance
+	for _, instance := range oldest {
+		if !included[instance.Id] {
+			reserved = append(reserved, instance)
+		}
+	}
+
+	if keep := limit - len(reserved); len(byPriority) > keep {
+		byPriority = byPriority[:keep]
+	}
+
+	return append(byPriority, reserved...)
+}
+
+// purge removes the completed instances past the retention.
+func (r *Runner[TxContext, R]) purge(ctx context.Context) error {
+	before := time.Now().Add(-r.config.Retention)
+
+	_, err := r.runInTx(ctx, func(tx TxContext) (Instance, error) {
+		_, err := r.automata.PurgeCompleted(tx, before)
+		return Instance{}, err
+	})
+
+	return err
+}
+
+// runnableStateNames returns the names of all states of the Automata that are not final.
+func (a *Automata[TxContext, _]) runnableStateNames() []string {
+	var names []string
+
+	for name := range a.stateConstructors {
+		if _, final := a.finalStates[name]; !final {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,216 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runner", func() {
+	type StateA struct {
+		State `name:"A"`
+		Fail  bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateExpired struct {
+		State `name:"Expired"`
+	}
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore()).WithDeadLetter(MaxAttempts(1))
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			if state.Fail {
+				return nil, errors.New("failed")
+			}
+
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateExpired) (string, error) {
+			return "expired", nil
+		})
+
+		a.WithExpiry(func(ctx context.Context, instance Instance) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateExpired{}).AsTuple()
+		})
+	})
+
+	stateOf := func(id InstanceId) string {
+		instance, err := a.Load(ctx, id)
+		Expect(err).ToNot(HaveOccurred())
+		return instance.StateName
+	}
+
+	It("executes runnable instances", func() {
+		running, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		expired, err := a.Start(ctx, StateA{}, WithDeadline(time.Now().Add(-time.Second)))
+		Expect(err).ToNot(HaveOccurred())
+
+		failing, err := a.Start(ctx, StateA{Fail: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		runner := NewRunner(a, DummyRunInTx, RunnerConfig{})
+
+		executed, err := runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(executed).To(Equal(3))
+
+		Expect(stateOf(running.Id)).To(Equal("B"))
+		Expect(stateOf(expired.Id)).To(Equal("Expired"))
+		Expect(stateOf(failing.Id)).To(Equal("A"))
+
+		// the failing instance was dead lettered and is not executed again
+		executed, err = runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(executed).To(Equal(0))
+	})
+
+	It("purges completed instances after the retention", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		runner := NewRunner(a, DummyRunInTx, RunnerConfig{Retention: time.Millisecond})
+
+		_, err = runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stateOf(instance.Id)).To(Equal("B"))
+
+		time.Sleep(2 * time.Millisecond)
+
+		_, err = runner.Poll(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Load(ctx, instance.Id)
+		Expect(err).To(MatchError(ErrNoSuchInstance))
+	})
+
+	It("polls right away when notified", func() {
+		notifier := NewBroadcaster()
+		polls := make(chan struct{}, 16)
+
+		a := New[string](pollSignalStore{Store: NewMemoryStore(), polls: polls}).WithNotifier(notifier)
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		runner := NewRunner(a, DummyRunInTx, RunnerConfig{PollInterval: time.Hour, MinPollInterval: time.Millisecond})
+		go func() { _ = runner.Run(runCtx) }()
+
+		// wait for the initial poll, it does not see the instance started afterwards
+		Eventually(polls).Should(Receive())
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		loaded, err := a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.StateName).To(Equal("A"))
+
+		notifier.Publish(instance.Id)
+
+		Eventually(func() string {
+			loaded, err := a.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			return loaded.StateName
+		}).Should(Equal("B"))
+	})
+
+	It("waits for the minimum poll interval when notified", func() {
+		notifier := unbufferedNotifier{changes: make(chan InstanceId)}
+		polls := make(chan struct{}, 16)
+
+		a := New[string](pollSignalStore{Store: NewMemoryStore(), polls: polls}).WithNotifier(notifier)
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		runCtx, cancel := context.WithCancel(ctx)
+
+		done := make(chan error)
+		runner := NewRunner(a, DummyRunInTx, RunnerConfig{PollInterval: time.Hour, MinPollInterval: time.Hour})
+		go func() { done <- runner.Run(runCtx) }()
+
+		Eventually(polls).Should(Receive())
+
+		// returns as soon as the runner received the notification
+		notifier.changes <- "1"
+
+		cancel()
+		Eventually(done).Should(Receive(MatchError(context.Canceled)))
+
+		Expect(polls).ToNot(Receive())
+	})
+
+	It("runs until the context is canceled", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		runCtx, cancel := context.WithCancel(ctx)
+
+		done := make(chan error)
+		go func() { done <- NewRunner(a, DummyRunInTx, RunnerConfig{PollInterval: time.Millisecond}).Run(runCtx) }()
+
+		Eventually(func() string { return stateOf(instance.Id) }).Should(Equal("B"))
+
+		cancel()
+		Eventually(done).Should(Receive(MatchError(context.Canceled)))
+	})
+})
+
+// pollSignalStore signals every poll of a Runner, after the runnable instances were listed.
+type pollSignalStore struct {
+	Store[context.Context]
+	polls chan struct{}
+}
+
+func (s pollSignalStore) List(ctx context.Context, query ListQuery) ([]*SerializedInstance, error) {
+	instances, err := s.Store.(ListStore[context.Context]).List(ctx, query)
+
+	// the oldest instances are listed last
+	if query.Order == OrderByCreation {
+		s.polls <- struct{}{}
+	}
+
+	return instances, err
+}
+
+// unbufferedNotifier delivers notifications without a buffer, so
+// sending a notification returns once a subscriber received it.
+type unbufferedNotifier struct {
+	changes chan InstanceId
+}
+
+func (n unbufferedNotifier) Subscribe() (<-chan InstanceId, func()) {
+	return n.changes, func() {}
+}
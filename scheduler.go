@@ -0,0 +1,257 @@
+package pee
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultSchedulerInterval is the default time between two checks of a Scheduler for due ticks.
+const DefaultSchedulerInterval = 10 * time.Second
+
+// DefaultMaxCatchUp is the default maximum number of missed ticks a Scheduler
+// starts instances for with a single check, see CatchUpAll.
+const DefaultMaxCatchUp = 100
+
+// Labels attached to the instances started by a Scheduler.
+const (
+	// ScheduleLabel holds the name of the Schedule that started the Instance.
+	ScheduleLabel = "schedule"
+
+	// TickLabel holds the time of the tick the Instance was started for, formatted as RFC 3339.
+	TickLabel = "schedule_tick"
+)
+
+// CatchUpPolicy defines how a Scheduler handles ticks that were missed,
+// e.g. because no Scheduler was running at the time of the tick.
+type CatchUpPolicy int
+
+const (
+	// CatchUpOnce starts a single Instance for all missed ticks.
+	CatchUpOnce CatchUpPolicy = iota
+
+	// CatchUpAll starts an Instance for every missed tick. At most MaxCatchUp instances
+	// are started per check, the remaining ticks are caught up with the following checks.
+	CatchUpAll
+
+	// CatchUpNone skips missed ticks. An Instance is only started, if the latest tick
+	// is not older than two intervals of the Scheduler.
+	CatchUpNone
+)
+
+// Schedule describes the instances a Scheduler starts.
+type Schedule struct {
+	// Name identifies the Schedule in the Store and must be unique.
+	Name string
+
+	// Cron is a cron expression with five fields, e.g. "0 3 * * *", or a descriptor like
+	// "@hourly". Ticks are computed in the local time zone, unless the expression is
+	// prefixed with a time zone, e.g. "CRON_TZ=Europe/Berlin 0 3 * * *".
+	Cron string
+
+	// State is the initial State of the started instances.
+	State State
+
+	// CatchUp defines how missed ticks are handled. Defaults to CatchUpOnce.
+	CatchUp CatchUpPolicy
+
+	// Options configure the started instances, e.g. WithLabel.
+	Options []StartOption
+}
+
+// SchedulerConfig configures a Scheduler.
+type SchedulerConfig struct {
+	// Interval is the time between two checks for due ticks.
+	// Defaults to DefaultSchedulerInterval.
+	Interval time.Duration
+
+	// MaxCatchUp is the maximum number of missed ticks of a Schedule that are started
+	// with a single check when using CatchUpAll. Defaults to DefaultMaxCatchUp.
+	MaxCatchUp int
+}
+
+// Scheduler starts new instances of an Automata on the ticks of cron schedules.
+// The last handled tick of every Schedule is persisted in the Store, which must
+// implement ScheduleStore. A new Schedule starts with the next tick after it was
+// first seen by a Scheduler.
+//
+// Multiple schedulers can run the same schedules on the same Store. The last tick of
+// a Schedule is updated using optimistic locking in the transaction that starts the
+// instances, so exactly one Instance is started per tick.
+type Scheduler[TxContext context.Context, R any] struct {
+	automata  *Automata[TxContext, R]
+	runInTx   RunInTx[TxContext, Instance]
+	config    SchedulerConfig
+	schedules []parsedSchedule
+}
+
+type parsedSchedule struct {
+	Schedule
+	cron cron.Schedule
+}
+
+// NewScheduler creates a new Scheduler for the given Automata. The instances of a tick
+// are started in a new transaction created by runInTx.
+func NewScheduler[TxContext context.Context, R any](automata *Automata[TxContext, R], runInTx RunInTx[TxContext, Instance], config SchedulerConfig) *Scheduler[TxContext, R] {
+	if config.Interval <= 0 {
+		config.Interval = DefaultSchedulerInterval
+	}
+
+	if config.MaxCatchUp <= 0 {
+		config.MaxCatchUp = DefaultMaxCatchUp
+	}
+
+	return &Scheduler[TxContext, R]{automata: automata, runInTx: runInTx, config: config}
+}
+
+// Add adds a Schedule to the Scheduler. It returns an error, if the cron expression is
+// invalid or the initial State is not registered with the Automata.
+// Schedules must be added before the Scheduler runs.
+func (s *Scheduler[TxContext, R]) Add(schedule Schedule) error {
+	parsed, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return wrap(err, "invalid cron expression of schedule %q", schedule.Name)
+	}
+
+	if err := s.automata.validateState(schedule.State); err != nil {
+		return wrap(err, "invalid state of schedule %q", schedule.Name)
+	}
+
+	s.schedules = append(s.schedules, parsedSchedule{Schedule: schedule, cron: parsed})
+
+	return nil
+}
+
+// Run checks for due ticks every Interval until the given context is done.
+// Failed checks are logged using the logger of the Automata. Run returns the error of the context.
+func (s *Scheduler[TxContext, R]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Tick(ctx); err != nil && ctx.Err() == nil {
+			s.automata.loggerOrDefault().ErrorContext(ctx, "Scheduling failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick checks all schedules once and starts the instances for their due ticks.
+// It returns the number of started instances.
+func (s *Scheduler[TxContext, R]) Tick(ctx context.Context) (int, error) {
+	now := time.Now()
+
+	var started int
+	var errs []error
+
+	for _, schedule := range s.schedules {
+		count, err := s.fire(ctx, schedule, now)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		started += count
+	}
+
+	return started, errors.Join(errs...)
+}
+
+// fire starts the instances for the due ticks of the schedule and persists the last tick.
+func (s *Scheduler[TxContext, R]) fire(ctx context.Context, schedule parsedSchedule, now time.Time) (int, error) {
+	store, ok := s.automata.store.(ScheduleStore[TxContext])
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	var started int
+
+	_, err := s.runInTx(ctx, func(tx TxContext) (Instance, error) {
+		started = 0
+
+		persisted, err := store.LoadSchedule(tx, schedule.Name, now)
+		if err != nil {
+			return Instance{}, err
+		}
+
+		ticks, lastTick := s.dueTicks(schedule, persisted.LastTick, now)
+		if lastTick.IsZero() {
+			return Instance{}, nil
+		}
+
+		// take the ticks first, a concurrent scheduler fails with optimistic locking
+		if err := store.UpdateSchedule(tx, schedule.Name, persisted.Version, lastTick); err != nil {
+			return Instance{}, err
+		}
+
+		for _, tick := range ticks {
+			opts := append([]StartOption{
+				WithLabel(ScheduleLabel, schedule.Name),
+				WithLabel(TickLabel, tick.Format(time.RFC3339)),
+			}, schedule.Options...)
+
+			if _, err := s.automata.Start(tx, schedule.State, opts...); err != nil {
+				return Instance{}, err
+			}
+
+			started++
+		}
+
+		return Instance{}, nil
+	})
+
+	switch {
+	case errors.Is(err, ErrOptimisticLocking):
+		// the ticks were handled by another scheduler
+		return 0, nil
+
+	case err != nil:
+		return 0, wrap(err, "schedule %q", schedule.Name)
+	}
+
+	return started, nil
+}
+
+// dueTicks returns the ticks after the last tick to start instances for, according to the
+// catch up policy of the schedule, and the new last tick. The new last tick is zero, if
+// no tick is due.
+func (s *Scheduler[TxContext, R]) dueTicks(schedule parsedSchedule, lastTick, now time.Time) ([]time.Time, time.Time) {
+	var ticks []time.Time
+	var latest time.Time
+
+	for tick := schedule.cron.Next(lastTick); !tick.IsZero() && !tick.After(now); tick = schedule.cron.Next(tick) {
+		latest = tick
+
+		if schedule.CatchUp == CatchUpAll {
+			ticks = append(ticks, tick)
+
+			if len(ticks) >= s.config.MaxCatchUp {
+				break
+			}
+		}
+	}
+
+	if latest.IsZero() {
+		return nil, latest
+	}
+
+	switch schedule.CatchUp {
+	case CatchUpOnce:
+		ticks = []time.Time{latest}
+
+	case CatchUpNone:
+		if now.Sub(latest) <= 2*s.config.Interval {
+			ticks = []time.Time{latest}
+		}
+	}
+
+	return ticks, latest
+}
@@ -0,0 +1,170 @@
+package pee
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateUnknown struct {
+		State `name:"Unknown"`
+	}
+
+	var store Store[context.Context]
+	var a *Automata[context.Context, string]
+	var scheduler *Scheduler[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		store = NewMemoryStore()
+		a = New[string](store)
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		scheduler = NewScheduler(a, DummyRunInTx, SchedulerConfig{})
+	})
+
+	// lastTickAt persists the last tick of the schedule with the given name.
+	lastTickAt := func(name string, lastTick time.Time) {
+		_, err := store.(ScheduleStore[context.Context]).LoadSchedule(ctx, name, lastTick)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	started := func() []Instance {
+		instances, err := a.List(ctx, ListQuery{})
+		Expect(err).ToNot(HaveOccurred())
+		return instances
+	}
+
+	It("validates the schedule", func() {
+		Expect(scheduler.Add(Schedule{Name: "invalid", Cron: "every day", State: StateA{}})).ToNot(Succeed())
+		Expect(scheduler.Add(Schedule{Name: "unknown", Cron: "@hourly", State: StateUnknown{}})).ToNot(Succeed())
+		Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "@hourly", State: StateA{}})).To(Succeed())
+	})
+
+	It("starts with the next tick after a schedule was added", func() {
+		Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "0 * * * *", State: StateA{}})).To(Succeed())
+
+		Expect(scheduler.Tick(ctx)).To(Equal(0))
+		Expect(started()).To(BeEmpty())
+	})
+
+	It("starts a single instance for missed ticks", func() {
+		lastTickAt("hourly", time.Now().Add(-3*time.Hour))
+
+		Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "0 * * * *", State: StateA{}, Options: []StartOption{WithPriority(5)}})).To(Succeed())
+
+		Expect(scheduler.Tick(ctx)).To(Equal(1))
+		Expect(scheduler.Tick(ctx)).To(Equal(0))
+
+		instances := started()
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].StateName).To(Equal("A"))
+		Expect(instances[0].Priority).To(Equal(5))
+		Expect(instances[0].Labels).To(HaveKeyWithValue(ScheduleLabel, "hourly"))
+
+		tick, err := time.Parse(time.RFC3339, instances[0].Labels[TickLabel])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tick).To(BeTemporally("<=", time.Now()))
+		Expect(tick).To(BeTemporally(">", time.Now().Add(-time.Hour)))
+	})
+
+	It("starts an instance for every missed tick", func() {
+		scheduler = NewScheduler(a, DummyRunInTx, SchedulerConfig{MaxCatchUp: 2})
+
+		lastTickAt("hourly", time.Now().Add(-3*time.Hour))
+
+		Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "0 * * * *", State: StateA{}, CatchUp: CatchUpAll})).To(Succeed())
+
+		// missed ticks are caught up in batches
+		Expect(scheduler.Tick(ctx)).To(Equal(2))
+		Expect(scheduler.Tick(ctx)).To(Equal(1))
+		Expect(scheduler.Tick(ctx)).To(Equal(0))
+
+		ticks := map[string]bool{}
+		for _, instance := range started() {
+			ticks[instance.Labels[TickLabel]] = true
+		}
+
+		Expect(ticks).To(HaveLen(3))
+	})
+
+	It("skips missed ticks", func() {
+		lastTickAt("yearly", time.Now().AddDate(-2, 0, 0))
+		lastTickAt("hourly", time.Now().Add(-3*time.Hour))
+
+		Expect(scheduler.Add(Schedule{Name: "yearly", Cron: "0 0 1 1 *", State: StateA{}, CatchUp: CatchUpNone})).To(Succeed())
+		Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "@every 1h", State: StateA{}, CatchUp: CatchUpNone})).To(Succeed())
+
+		// only the current tick of the hourly schedule is started
+		Expect(scheduler.Tick(ctx)).To(Equal(1))
+		Expect(scheduler.Tick(ctx)).To(Equal(0))
+
+		instances := started()
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].Labels).To(HaveKeyWithValue(ScheduleLabel, "hourly"))
+	})
+
+	It("starts exactly one instance per tick across schedulers", func() {
+		lastTickAt("hourly", time.Now().Add(-3*time.Hour))
+
+		var wg sync.WaitGroup
+
+		for idx := 0; idx < 5; idx++ {
+			scheduler := NewScheduler(a, DummyRunInTx, SchedulerConfig{})
+			Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "0 * * * *", State: StateA{}, CatchUp: CatchUpAll})).To(Succeed())
+
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				_, err := scheduler.Tick(ctx)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+		}
+
+		wg.Wait()
+
+		Expect(started()).To(HaveLen(3))
+	})
+
+	It("requires a store that supports schedules", func() {
+		a := New[string](noScheduleStore{store})
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		scheduler := NewScheduler(a, DummyRunInTx, SchedulerConfig{})
+		Expect(scheduler.Add(Schedule{Name: "hourly", Cron: "@hourly", State: StateB{}})).To(Succeed())
+
+		_, err := scheduler.Tick(ctx)
+		Expect(err).To(MatchError(ErrNotSupported))
+	})
+})
+
+// noScheduleStore hides the optional interfaces of a Store.
+type noScheduleStore struct {
+	Store[context.Context]
+}
@@ -0,0 +1,67 @@
+package pee
+
+import "time"
+
+// StartOption configures a new Instance when calling Automata.Start.
+type StartOption func(opts *startOptions)
+
+type startOptions struct {
+	id       InstanceId
+	labels   map[string]string
+	deadline time.Time
+	priority int
+}
+
+// WithId uses the given id for the new Instance instead of generating one.
+func WithId(id InstanceId) StartOption {
+	return func(opts *startOptions) {
+		opts.id = id
+	}
+}
+
+// WithLabels attaches the given labels to a new Instance. Labels are
+// persisted with the instance and can be used to store things like a
+// tenant or a customer id. Calling WithLabels multiple times merges the labels.
+func WithLabels(labels map[string]string) StartOption {
+	return func(opts *startOptions) {
+		if opts.labels == nil {
+			opts.labels = map[string]string{}
+		}
+
+		for key, value := range labels {
+			opts.labels[key] = value
+		}
+	}
+}
+
+// WithLabel attaches a single label to a new Instance. See WithLabels.
+func WithLabel(key, value string) StartOption {
+	return WithLabels(map[string]string{key: value})
+}
+
+// WithDeadline sets the time a new Instance expires, if it has not reached a final State
+// until then. See Automata.WithExpiry.
+func WithDeadline(deadline time.Time) StartOption {
+	return func(opts *startOptions) {
+		opts.deadline = deadline
+	}
+}
+
+// WithPriority sets the priority of a new Instance. Runners execute instances with a
+// higher priority first. Defaults to zero, negative priorities are allowed.
+// The priority can be changed by a transition, see StateTransition.WithPriority.
+func WithPriority(priority int) StartOption {
+	return func(opts *startOptions) {
+		opts.priority = priority
+	}
+}
+
+func applyStartOptions(opts []StartOption) startOptions {
+	var options startOptions
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
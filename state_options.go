@@ -0,0 +1,85 @@
+package pee
+
+import "time"
+
+// StateOption configures the execution of a State when calling AddState.
+type StateOption func(opts *stateOptions)
+
+type stateOptions struct {
+	timeout      time.Duration
+	timeoutState State
+
+	concurrency int
+	rate        int
+	ratePer     time.Duration
+	sharedKey   string
+}
+
+// WithTimeout limits the execution time of the states Handler. The Handler receives a
+// context with a deadline and must return once the context is done. If the Handler
+// fails after the deadline exceeded, the Instance is moved to the State configured
+// using WithTimeoutState. Without a timeout State, the handlers error is returned.
+//
+// The timeout is cooperative: the Handler is not interrupted. A Handler that ignores
+// its context keeps blocking the caller, e.g. a Runner, until it returns. If it succeeds
+// after the deadline exceeded, its transition is applied as usual.
+func WithTimeout(timeout time.Duration) StateOption {
+	return func(opts *stateOptions) {
+		opts.timeout = timeout
+	}
+}
+
+// WithTimeoutState configures the State an Instance is moved to, if the Handler
+// exceeds the timeout configured using WithTimeout. The State must be registered
+// with the Automata.
+func WithTimeoutState(state State) StateOption {
+	return func(opts *stateOptions) {
+		opts.timeoutState = state
+	}
+}
+
+// WithConcurrencyLimit limits the number of handlers of the State that run concurrently
+// within the process. If the limit is reached, Automata.Execute returns a LimitError
+// without running the Handler and runners retry the Instance with a later poll.
+// Use WithSharedLimits to share the limit between processes.
+func WithConcurrencyLimit(limit int) StateOption {
+	return func(opts *stateOptions) {
+		opts.concurrency = limit
+	}
+}
+
+// WithRateLimit limits the rate at which handlers of the State are started within the
+// process to limit calls per interval, using a token bucket. The bucket holds up to limit
+// tokens, so short bursts of up to limit calls are allowed. If the bucket is empty,
+// Automata.Execute returns a LimitError without running the Handler and runners retry
+// the Instance with a later poll. Use WithSharedLimits to share the limit between processes.
+func WithRateLimit(limit int, per time.Duration) StateOption {
+	return func(opts *stateOptions) {
+		opts.rate = limit
+		opts.ratePer = per
+	}
+}
+
+// WithSharedLimits shares the limits configured using WithConcurrencyLimit and
+// WithRateLimit between all processes through the Store, which must implement LimitStore.
+// The limits of all states using the same key are shared, e.g. to limit the calls to
+// the same third-party API from multiple states.
+func WithSharedLimits(key string) StateOption {
+	return func(opts *stateOptions) {
+		opts.sharedKey = key
+	}
+}
+
+func (opts stateOptions) limited() bool {
+	return opts.concurrency > 0 || (opts.rate > 0 && opts.ratePer > 0)
+}
+
+func applyStateOptions(opts []StateOption) stateOptions {
+	var options stateOptions
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
@@ -0,0 +1,134 @@
+package pee
+
+import (
+	"context"
+)
+
+// WithMaxSteps limits the number of transitions of a single execution, e.g. by Execute or
+// ExecuteUntil. An execution that exceeds the limit fails with a MaxStepsError, which
+// describes the cycle of states the Instance is caught in. No limit is applied if zero.
+func (a *Automata[TxContext, R]) WithMaxSteps(maxSteps int) *Automata[TxContext, R] {
+	a.maxSteps = maxSteps
+	return a
+}
+
+// Step applies exactly one transition to the given Instance and returns the new Instance.
+// If the Instance is already in a final State, ErrFinalState is returned.
+func (a *Automata[TxContext, R]) Step(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance) (Instance, error) {
+	if a.isFinal(instance) {
+		return instance, ErrFinalState
+	}
+
+	return a.ExecuteN(ctx, runInTx, instance, 1)
+}
+
+// ExecuteN applies at most n transitions to the given Instance and returns the new Instance.
+// The execution stops early if the Instance reaches a final State. The Transform of the
+// final State is not called, use Execute to get the result of the Instance.
+func (a *Automata[TxContext, R]) ExecuteN(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance, n int) (Instance, error) {
+	if n <= 0 {
+		return instance, nil
+	}
+
+	err := a.aroundExecute(ctx, &instance, func(ctx context.Context) error {
+		return a.advance(ctx, runInTx, &instance, n, nil)
+	})
+
+	return instance, err
+}
+
+// ExecuteUntil applies transitions to the given Instance until the predicate returns true
+// or the Instance reaches a final State and returns the new Instance. The predicate is
+// checked before each transition. The Transform of the final State is not called.
+func (a *Automata[TxContext, R]) ExecuteUntil(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance Instance, predicate func(instance Instance) bool) (Instance, error) {
+	err := a.aroundExecute(ctx, &instance, func(ctx context.Context) error {
+		return a.advance(ctx, runInTx, &instance, 0, predicate)
+	})
+
+	return instance, err
+}
+
+// advance applies transitions until the instance reaches a final state, the predicate
+// returns true or the given number of steps was applied. No limit is applied if steps is zero.
+func (a *Automata[TxContext, R]) advance(ctx context.Context, runInTx RunInTx[TxContext, Instance], instance *Instance, steps int, predicate func(Instance) bool) error {
+	// dead lettered instances need to be re-driven first
+	if instance.DeadLetter {
+		return &DeadLetterError{ErrorContext: errorContextOf(*instance)}
+	}
+
+	guard := newStepGuard(a.maxSteps)
+
+	for count := 0; steps == 0 || count < steps; count++ {
+		if a.isFinal(*instance) || predicate != nil && predicate(*instance) {
+			return nil
+		}
+
+		// stop before the next step, if the execution was canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		a.hooks.beforeStep(ctx, *instance)
+
+		if err := guard.visit(*instance); err != nil {
+			return err
+		}
+
+		if err := a.step(ctx, runInTx, instance); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isFinal returns true if the instance is in a final state.
+func (a *Automata[TxContext, R]) isFinal(instance Instance) bool {
+	_, final := a.finalStates[NameOf(instance.State)]
+	return final
+}
+
+// stepGuard limits the number of steps of an execution and
+// records the visited states to describe a cycle.
+type stepGuard struct {
+	maxSteps int
+	visited  []string
+}
+
+func newStepGuard(maxSteps int) *stepGuard {
+	return &stepGuard{maxSteps: maxSteps}
+}
+
+// visit records a step from the given instance. It returns a MaxStepsError,
+// if the step would exceed the maximum number of steps.
+func (g *stepGuard) visit(instance Instance) error {
+	if g.maxSteps <= 0 {
+		return nil
+	}
+
+	g.visited = append(g.visited, NameOf(instance.State))
+
+	if len(g.visited) <= g.maxSteps {
+		return nil
+	}
+
+	return &MaxStepsError{
+		ErrorContext: errorContextOf(instance),
+		MaxSteps:     g.maxSteps,
+		Cycle:        lastCycle(g.visited),
+	}
+}
+
+// lastCycle returns the states between the last state and its previous occurrence,
+// including both occurrences. Returns nil, if the last state was not visited before.
+func lastCycle(stateNames []string) []string {
+	last := len(stateNames) - 1
+
+	for idx := last - 1; idx >= 0; idx-- {
+		if stateNames[idx] == stateNames[last] {
+			return stateNames[idx:]
+		}
+	}
+
+	return nil
+}
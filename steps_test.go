@@ -0,0 +1,124 @@
+package pee
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bounded execution", func() {
+	type StateA struct {
+		State `name:"A"`
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateC struct {
+		State `name:"C"`
+	}
+
+	type StatePing struct {
+		State `name:"Ping"`
+	}
+
+	type StatePong struct {
+		State `name:"Pong"`
+	}
+
+	type StateDone struct {
+		State `name:"Done"`
+	}
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore())
+
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateB{}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StateB) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StateC{}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StateC) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StatePing{}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StatePing) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StatePong{}).AsTuple()
+		})
+
+		AddState(a, func(ctx context.Context, state StatePong) (*StateTransition[context.Context], error) {
+			return Transition[context.Context](StatePing{}).AsTuple()
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateDone) (string, error) {
+			return "done", nil
+		})
+	})
+
+	It("applies a single step", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = a.Step(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("B"))
+		Expect(instance.Version).To(Equal(2))
+
+		loaded, err := a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.StateName).To(Equal("B"))
+	})
+
+	It("does not step an instance in a final state", func() {
+		instance, err := a.Start(ctx, StateDone{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Step(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ErrFinalState))
+	})
+
+	It("applies a bounded number of steps", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = a.ExecuteN(ctx, DummyRunInTx, instance, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("C"))
+	})
+
+	It("executes until the predicate matches", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		instance, err = a.ExecuteUntil(ctx, DummyRunInTx, instance, func(instance Instance) bool {
+			return instance.StateName == "Ping"
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("Ping"))
+	})
+
+	It("stops an endless execution and describes the cycle", func() {
+		a.WithMaxSteps(6)
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(ContainSubstring("execution exceeded 6 steps")))
+		Expect(err).To(MatchError(ContainSubstring("cycle detected: Pong -> Ping -> Pong")))
+
+		var maxStepsErr *MaxStepsError
+		Expect(err).To(BeAssignableToTypeOf(maxStepsErr))
+		Expect(IsRetryable(err)).To(BeFalse())
+	})
+})
@@ -2,27 +2,268 @@ package pee
 
 import (
 	"context"
+	"time"
 )
 
 var ErrOptimisticLocking = makeErr("optimistic locking failed")
 
 type SerializedInstance struct {
-	Id      int
+	Id      InstanceId
 	Version int
 	State   []byte
+
+	// StateName is the name of the serialized state.
+	StateName string
+
+	// Labels as provided when starting the instance.
+	Labels map[string]string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Attempts is the number of failed attempts in the current state.
+	Attempts int
+
+	// LastError is the error message of the last failed attempt in the current state.
+	LastError string
+
+	// LastErrorStack is the stack trace of the last failed attempt, if it was caused by a panic.
+	LastErrorStack string
+
+	// DeadLetter is true if the instance failed permanently, see Automata.WithDeadLetter.
+	DeadLetter bool
+
+	// Deadline is the time the instance expires, zero if the instance never expires.
+	Deadline time.Time
+
+	// Priority of the instance, higher priorities are executed first.
+	Priority int
+
+	// Partition of the instance, see Automata.WithPartitions. It is assigned when the
+	// instance is created and never changes.
+	Partition int
+
+	// Result is the serialized result of the final state, if persisted. See ResultStore.
+	Result []byte
+
+	// TraceContext is the propagated trace context of the last transition.
+	TraceContext map[string]string
 }
 
 type Store[TxContext context.Context] interface {
-	// Update needs to update the state of the Instance identified by the given id and version.
+	// Update needs to update the state of the Instance identified by the instances id and version.
+	// The store needs to persist the State, StateName, Deadline, Priority and TraceContext of the given instance
+	// and reset the number of failed Attempts, the LastError, LastErrorStack and DeadLetter flag.
 	// Implementations should use optimistic locking and only update the instance,
-	// if the version matches. The implementation needs to return the new version of the entity.
+	// if the version matches. The implementation needs to return the new version of the entity
+	// with an updated UpdatedAt timestamp.
 	// If optimistic locking fails this method should return ErrOptimisticLocking
-	Update(ctx TxContext, id, version int, newState []byte) (*SerializedInstance, error)
+	Update(ctx TxContext, instance SerializedInstance) (*SerializedInstance, error)
 
-	// Create needs to store create a new entity for the given serialized state.
+	// Create needs to store create a new entity for the given serialized instance, including its Partition.
+	// If the instance has no Id, the store needs to assign one. The store also assigns
+	// Version and the timestamps of the instance.
 	// It needs to return the created SerializedInstance.
-	Create(ctx TxContext, state []byte) (*SerializedInstance, error)
+	Create(ctx TxContext, instance SerializedInstance) (*SerializedInstance, error)
 
 	// Load needs to load the state of the Instance identified by the given id
-	Load(ctx TxContext, id int) (*SerializedInstance, error)
+	Load(ctx TxContext, id InstanceId) (*SerializedInstance, error)
+}
+
+// ListQuery filters the instances returned by ListStore.List.
+type ListQuery struct {
+	// StateNames restricts the result to instances in one of the given states.
+	// All states match if empty.
+	StateNames []string
+
+	// DeadLetter filters instances by their dead letter flag. Includes all instances by default.
+	DeadLetter DeadLetterFilter
+
+	// Order of the returned instances. Defaults to OrderByCreation.
+	Order ListOrder
+
+	// FairnessLabel is the name of a label to share the result fairly between the values
+	// of the label, e.g. "tenant". If set, the instances are interleaved round robin across
+	// the values of the label, each value ordered by Order.
+	FairnessLabel string
+
+	// Partitions restricts the result to instances in one of the given partitions.
+	// All partitions match if empty.
+	Partitions []int
+
+	// Limit is the maximum number of instances to return. No limit is applied if zero.
+	Limit int
+}
+
+// ListOrder is the order of the instances returned by ListStore.List.
+type ListOrder int
+
+const (
+	// OrderByCreation orders instances by creation, oldest instances first.
+	OrderByCreation ListOrder = iota
+
+	// OrderByPriority orders instances by priority, highest priorities first.
+	// Instances of the same priority are ordered by creation.
+	OrderByPriority
+)
+
+// DeadLetterFilter selects instances by their dead letter flag, see ListQuery.
+type DeadLetterFilter int
+
+const (
+	// IncludeDeadLetters matches all instances.
+	IncludeDeadLetters DeadLetterFilter = iota
+
+	// ExcludeDeadLetters matches only instances that are not dead lettered.
+	ExcludeDeadLetters
+
+	// OnlyDeadLetters matches only dead lettered instances.
+	OnlyDeadLetters
+)
+
+// Matches returns true if an instance with the given dead letter flag passes the filter.
+func (f DeadLetterFilter) Matches(deadLetter bool) bool {
+	switch f {
+	case ExcludeDeadLetters:
+		return !deadLetter
+	case OnlyDeadLetters:
+		return deadLetter
+	default:
+		return true
+	}
+}
+
+// ListStore is an optional interface a Store can implement to support listing instances.
+type ListStore[TxContext context.Context] interface {
+	// List returns all instances that match the given query in the order of the query.
+	// If the store is scoped to an automata type, only instances of that type must be returned.
+	List(ctx TxContext, query ListQuery) ([]*SerializedInstance, error)
+}
+
+// Failure describes a failed attempt to leave the current state of an Instance.
+type Failure struct {
+	// Error is the message of the error that caused the attempt to fail.
+	Error string
+
+	// Stack is the stack trace of a recovered panic, empty for other errors.
+	Stack string
+
+	// DeadLetter marks the instance as permanently failed.
+	DeadLetter bool
+}
+
+// FailureStore is an optional interface a Store can implement to track failed attempts.
+type FailureStore[TxContext context.Context] interface {
+	// RecordFailure increments the number of failed attempts of the Instance identified
+	// by the given id and version and persists the error message, stack trace and
+	// dead letter flag of the given Failure. If the version does not match,
+	// this method should return ErrOptimisticLocking.
+	RecordFailure(ctx TxContext, id InstanceId, version int, failure Failure) error
+
+	// ClearFailure resets the number of failed attempts, the last error and the dead
+	// letter flag of the Instance identified by the given id and version. If the version
+	// does not match, this method should return ErrOptimisticLocking.
+	ClearFailure(ctx TxContext, id InstanceId, version int) error
+}
+
+// PurgeQuery selects the instances removed by PurgeStore.Purge.
+type PurgeQuery struct {
+	// StateNames restricts the removed instances to instances in one of the given states.
+	// Must not be empty.
+	StateNames []string
+
+	// UpdatedBefore restricts the removed instances to instances that were last
+	// updated before the given time.
+	UpdatedBefore time.Time
+}
+
+// PurgeStore is an optional interface a Store can implement to remove instances.
+type PurgeStore[TxContext context.Context] interface {
+	// Purge removes all instances that match the given query, including their history,
+	// and returns the number of removed instances.
+	// If the store is scoped to an automata type, only instances of that type must be removed.
+	Purge(ctx TxContext, query PurgeQuery) (int, error)
+}
+
+// ResultStore is an optional interface a Store can implement to persist the results
+// of final states, see Automata.WithResultPersistence.
+type ResultStore[TxContext context.Context] interface {
+	// StoreResult persists the serialized result of the Instance identified by the given
+	// id and version. The result must be returned as SerializedInstance.Result when loading
+	// the instance. If the version does not match, this method should return ErrOptimisticLocking.
+	StoreResult(ctx TxContext, id InstanceId, version int, result []byte) error
+}
+
+// CountStore is an optional interface a Store can implement to count instances.
+type CountStore[TxContext context.Context] interface {
+	// CountByState returns the number of instances per state name.
+	// If the store is scoped to an automata type, only instances of that type must be counted.
+	CountByState(ctx TxContext) (map[string]int, error)
+}
+
+// LimitStore is an optional interface a Store can implement to share the concurrency
+// and rate limits of states between processes, see WithSharedLimits.
+type LimitStore[TxContext context.Context] interface {
+	// AcquireSlot takes one of the given number of slots of the key for the owner and
+	// returns true. The slot is held until it is released or until it expires at the given
+	// time. Returns false, if all slots of the key are held by other owners.
+	AcquireSlot(ctx TxContext, key string, slots int, owner string, expiresAt time.Time) (bool, error)
+
+	// ReleaseSlot releases the slot of the key held by the owner.
+	ReleaseSlot(ctx TxContext, key string, owner string) error
+
+	// TakeToken takes a token from the token bucket of the key and returns true. The bucket
+	// holds up to limit tokens and is refilled with limit tokens per interval.
+	// Returns false, if the bucket is empty.
+	TakeToken(ctx TxContext, key string, limit int, per time.Duration) (bool, error)
+}
+
+// PartitionStore is an optional interface a Store can implement to coordinate the
+// assignment of partitions to a group of runners, see Automata.WithPartitions.
+type PartitionStore[TxContext context.Context] interface {
+	// Heartbeat registers the worker as a member of the group until the given time, or
+	// extends its membership. It returns the ids of all members of the group whose
+	// membership did not expire, sorted by id.
+	Heartbeat(ctx TxContext, group string, worker string, expiresAt time.Time) ([]string, error)
+
+	// Leave removes the worker from the group and releases all partitions claimed by it.
+	Leave(ctx TxContext, group string, worker string) error
+
+	// ClaimPartition claims the partition of the group for the worker until the given
+	// time and returns true. A worker can extend its own claim. Returns false, if the
+	// partition is claimed by another worker and the claim did not expire.
+	ClaimPartition(ctx TxContext, group string, partition int, worker string, expiresAt time.Time) (bool, error)
+
+	// ReleasePartition releases the claim of the worker on the partition of the group.
+	ReleasePartition(ctx TxContext, group string, partition int, worker string) error
+}
+
+// SerializedSchedule is the persisted state of a schedule of a Scheduler.
+type SerializedSchedule struct {
+	Name string
+
+	// LastTick is the time of the last tick of the schedule that was handled.
+	LastTick time.Time
+
+	Version int
+}
+
+// ScheduleStore is an optional interface a Store can implement to persist the schedules of a Scheduler.
+type ScheduleStore[TxContext context.Context] interface {
+	// LoadSchedule loads the schedule with the given name. If the schedule does not
+	// exist yet, it is created with the given last tick and version 1.
+	LoadSchedule(ctx TxContext, name string, lastTick time.Time) (*SerializedSchedule, error)
+
+	// UpdateSchedule sets the last tick of the schedule identified by the given name
+	// and version and increments its version. If the version does not match,
+	// this method should return ErrOptimisticLocking.
+	UpdateSchedule(ctx TxContext, name string, version int, lastTick time.Time) error
+}
+
+// BatchStore is an optional interface a Store can implement to create many instances at once.
+type BatchStore[TxContext context.Context] interface {
+	// CreateMany creates new entities for all given serialized instances, like Create does for a
+	// single instance, but with as few round trips as possible. Either all instances are created
+	// or none. It needs to return the created instances in the order of the given instances.
+	CreateMany(ctx TxContext, instances []SerializedInstance) ([]*SerializedInstance, error)
 }
@@ -0,0 +1,180 @@
+package pee_pg
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"pee"
+	"strings"
+	"time"
+)
+
+// DefaultCreateBatchSize is the default maximum number of instances inserted with a single
+// statement. It keeps the number of parameters of a statement below the limits of the databases.
+const DefaultCreateBatchSize = 500
+
+var _ pee.BatchStore[ql.TxContext] = PostgresStore{}
+
+// CreateMany creates all given instances. Instances with an id are inserted with multi-row
+// inserts of up to CreateBatchSize instances each. Instances without an id are inserted with
+// a statement each, as neither Postgres nor sqlite guarantee the order of the rows returned
+// by a multi-row insert, so the ids assigned by the database could not be matched to the
+// instances. Use pee.Automata.WithIdGenerator to create many instances efficiently.
+func (s PostgresStore) CreateMany(ctx ql.TxContext, instances []pee.SerializedInstance) ([]*pee.SerializedInstance, error) {
+	now := time.Now()
+	batchSize := s.createBatchSize()
+
+	created := make([]*pee.SerializedInstance, 0, len(instances))
+
+	for len(instances) > 0 {
+		if instances[0].Id == "" {
+			instance, err := s.insert(ctx, instances[0], now)
+			if err != nil {
+				return nil, err
+			}
+
+			created = append(created, &instance)
+			instances = instances[1:]
+
+			continue
+		}
+
+		// take the next chunk of instances that all have an id
+		size := 1
+		for size < len(instances) && size < batchSize && instances[size].Id != "" {
+			size++
+		}
+
+		chunk, err := s.insertMany(ctx, instances[:size], now)
+		if err != nil {
+			return nil, err
+		}
+
+		created = append(created, chunk...)
+		instances = instances[size:]
+	}
+
+	if err := s.recordHistoryMany(ctx, created); err != nil {
+		return nil, err
+	}
+
+	if err := s.notifyMany(ctx, created); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// insertMany inserts the given instances with a single statement.
+// All instances must have an id, so all rows have the same columns.
+func (s PostgresStore) insertMany(ctx ql.TxContext, instances []pee.SerializedInstance, now time.Time) ([]*pee.SerializedInstance, error) {
+	var columns []string
+	var rows []string
+	var args []any
+
+	for _, instance := range instances {
+		instanceColumns, values, err := s.insertValues(instance, now)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = instanceColumns
+
+		var placeholders []string
+		for _, value := range values {
+			args = append(args, value)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %q (%s) VALUES %s`, s.Table,
+		strings.Join(columns, ", "), strings.Join(rows, ", "))
+
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("insert %d instances: %w", len(instances), err)
+	}
+
+	if affected != len(instances) {
+		return nil, fmt.Errorf("insert %d instances: %d rows inserted", len(instances), affected)
+	}
+
+	created := make([]*pee.SerializedInstance, 0, len(instances))
+	for _, instance := range instances {
+		row := createdInstance(instance, instance.Id, now)
+		created = append(created, &row)
+	}
+
+	return created, nil
+}
+
+// recordHistoryMany records the initial versions of the given new instances with a single
+// statement per chunk. New instances have a single version, so there is nothing to prune.
+func (s PostgresStore) recordHistoryMany(ctx ql.TxContext, instances []*pee.SerializedInstance) error {
+	if s.History != HistoryTable {
+		return nil
+	}
+
+	batchSize := s.createBatchSize()
+
+	for start := 0; start < len(instances); start += batchSize {
+		chunk := instances[start:min(start+batchSize, len(instances))]
+
+		var rows []string
+		var args []any
+
+		for _, instance := range chunk {
+			args = append(args, string(instance.Id), instance.Version, instance.StateName, instance.State, instance.UpdatedAt)
+
+			n := len(args)
+			rows = append(rows, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n-4, n-3, n-2, n-1, n))
+		}
+
+		stmt := fmt.Sprintf(`INSERT INTO %q ("instance_id", "version", "state_name", "state", "created_at") VALUES %s`,
+			s.historyTable(), strings.Join(rows, ", "))
+
+		if err := ql.Exec(ctx, stmt, args...); err != nil {
+			return fmt.Errorf("record history of %d instances: %w", len(chunk), err)
+		}
+	}
+
+	return nil
+}
+
+// notifyMany sends a notification for each of the given instances with a single statement per chunk.
+func (s PostgresStore) notifyMany(ctx ql.TxContext, instances []*pee.SerializedInstance) error {
+	if s.NotifyChannel == "" {
+		return nil
+	}
+
+	batchSize := s.createBatchSize()
+
+	for start := 0; start < len(instances); start += batchSize {
+		chunk := instances[start:min(start+batchSize, len(instances))]
+
+		args := []any{s.NotifyChannel}
+
+		var rows []string
+		for _, instance := range chunk {
+			args = append(args, string(instance.Id))
+			rows = append(rows, fmt.Sprintf("($%d)", len(args)))
+		}
+
+		stmt := fmt.Sprintf(`SELECT pg_notify($1, "ids"."id") FROM (VALUES %s) AS "ids"("id")`, strings.Join(rows, ", "))
+
+		if err := ql.Exec(ctx, stmt, args...); err != nil {
+			return fmt.Errorf("notify channel %q: %w", s.NotifyChannel, err)
+		}
+	}
+
+	return nil
+}
+
+func (s PostgresStore) createBatchSize() int {
+	if s.CreateBatchSize > 0 {
+		return s.CreateBatchSize
+	}
+
+	return DefaultCreateBatchSize
+}
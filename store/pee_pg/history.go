@@ -0,0 +1,142 @@
+package pee_pg
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"pee"
+	"time"
+)
+
+// HistoryMode defines how a PostgresStore records the previous states of an instance.
+type HistoryMode int
+
+const (
+	// HistoryLog appends the previous state to the jsonb "log" column of the instance
+	// on every update. This rewrites an ever-growing value on each step and is only kept
+	// for compatibility with existing tables.
+	HistoryLog HistoryMode = iota
+
+	// HistoryNone does not record any history.
+	HistoryNone
+
+	// HistoryTable writes each state of an instance as a new row into a companion
+	// history table within the same transaction. The history table needs the following columns:
+	//
+	//	"instance_id" -- same type as the "id" column of the instances table
+	//	"version"     integer     NOT NULL,
+	//	"state_name"  text        NOT NULL,
+	//	"state"       jsonb       NOT NULL,
+	//	"created_at"  timestamptz NOT NULL,
+	//	PRIMARY KEY ("instance_id", "version")
+	HistoryTable
+)
+
+// HistoryEntry is a single recorded state of an instance.
+type HistoryEntry struct {
+	InstanceId string    `db:"instance_id"`
+	Version    int       `db:"version"`
+	StateName  string    `db:"state_name"`
+	State      []byte    `db:"state"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// LoadHistory returns the recorded states of the instance with the given id, oldest entries first.
+// This is only supported in HistoryTable mode.
+func (s PostgresStore) LoadHistory(ctx ql.TxContext, id pee.InstanceId) ([]HistoryEntry, error) {
+	if s.History != HistoryTable {
+		return nil, pee.ErrNotSupported
+	}
+
+	query := fmt.Sprintf(`SELECT "instance_id", "version", "state_name", "state", "created_at" FROM %q WHERE "instance_id"=$1 ORDER BY "version"`, s.historyTable())
+
+	entries, err := ql.Select[HistoryEntry](ctx, query, string(id))
+	if err != nil {
+		return nil, fmt.Errorf("loading history of instance id=%s: %w", id, err)
+	}
+
+	return entries, nil
+}
+
+// PruneHistory removes all entries from the history table that were recorded before the given time.
+// It returns the number of removed entries.
+func (s PostgresStore) PruneHistory(ctx ql.TxContext, before time.Time) (int, error) {
+	stmt := fmt.Sprintf(`DELETE FROM %q WHERE "created_at" < $1`, s.historyTable())
+
+	affected, err := ql.ExecAffected(ctx, stmt, before)
+	if err != nil {
+		return 0, fmt.Errorf("prune history: %w", err)
+	}
+
+	return affected, nil
+}
+
+// MigrateLogToHistory copies the entries of the legacy jsonb "log" column as well as the
+// current state of every instance into the history table and clears the "log" column afterwards.
+// The original time of a log entry is unknown, the migration uses the creation time of
+// the instance instead. Entries that already exist in the history table are skipped, so the
+// migration can safely be re-run. This is meant to be run offline, before switching
+// a store to HistoryTable mode. It only works on Postgres.
+func (s PostgresStore) MigrateLogToHistory(ctx ql.TxContext) error {
+	where, args := s.scope(`TRUE`)
+
+	// the last log entry is the state right before the current version
+	stmt := fmt.Sprintf(`
+		INSERT INTO %q ("instance_id", "version", "state_name", "state", "created_at")
+		SELECT t."id", t."version" - jsonb_array_length(t."log"::jsonb) - 1 + e.idx, e.value->>'state', e.value, t."created_at"
+		FROM %q t, jsonb_array_elements(t."log"::jsonb) WITH ORDINALITY AS e(value, idx)
+		WHERE %s
+		ON CONFLICT DO NOTHING`,
+		s.historyTable(), s.Table, where)
+
+	if err := ql.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("migrate log entries to history: %w", err)
+	}
+
+	stmt = fmt.Sprintf(`
+		INSERT INTO %q ("instance_id", "version", "state_name", "state", "created_at")
+		SELECT t."id", t."version", t."state_name", t."state", t."updated_at"
+		FROM %q t
+		WHERE %s
+		ON CONFLICT DO NOTHING`,
+		s.historyTable(), s.Table, where)
+
+	if err := ql.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("migrate current states to history: %w", err)
+	}
+
+	stmt = fmt.Sprintf(`UPDATE %q SET "log"='[]' WHERE %s`, s.Table, where)
+	if err := ql.Exec(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("clear log column: %w", err)
+	}
+
+	return nil
+}
+
+// recordHistory writes the given instance into the history table, if enabled.
+func (s PostgresStore) recordHistory(ctx ql.TxContext, instance pee.SerializedInstance) error {
+	if s.History != HistoryTable {
+		return nil
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("instance_id", "version", "state_name", "state", "created_at") VALUES ($1, $2, $3, $4, $5)`, s.historyTable())
+	if err := ql.Exec(ctx, stmt, string(instance.Id), instance.Version, instance.StateName, instance.State, instance.UpdatedAt); err != nil {
+		return fmt.Errorf("record history of instance %s@%d: %w", instance.Id, instance.Version, err)
+	}
+
+	if s.HistoryLimit > 0 {
+		stmt := fmt.Sprintf(`DELETE FROM %q WHERE "instance_id"=$1 AND "version"<=$2`, s.historyTable())
+		if err := ql.Exec(ctx, stmt, string(instance.Id), instance.Version-s.HistoryLimit); err != nil {
+			return fmt.Errorf("prune history of instance %s: %w", instance.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func (s PostgresStore) historyTable() string {
+	if s.HistoryTableName != "" {
+		return s.HistoryTableName
+	}
+
+	return s.Table + "_history"
+}
@@ -0,0 +1,136 @@
+package pee_pg
+
+import (
+	"context"
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"pee"
+)
+
+// The specs require a local Postgres database, see the specs of the notifications.
+var _ = Describe("Postgres history migration", func() {
+	var db *sqlx.DB
+	var store PostgresStore
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		url := os.Getenv("PEE_POSTGRES_URL")
+		if url == "" {
+			Skip("PEE_POSTGRES_URL is not set")
+		}
+
+		db = sqlx.MustOpen("pgx", url)
+		DeferCleanup(db.Close)
+
+		db.MustExec(`
+			CREATE TABLE "pee_history_test" (
+				"id"               serial      NOT NULL PRIMARY KEY,
+				"version"          integer     NOT NULL,
+				"state"            jsonb       NOT NULL,
+				"state_name"       text        NOT NULL,
+				"labels"           jsonb,
+				"created_at"       timestamptz NOT NULL,
+				"updated_at"       timestamptz NOT NULL,
+				"trace_context"    jsonb,
+				"attempts"         integer     NOT NULL DEFAULT 0,
+				"last_error"       text,
+				"last_error_stack" text,
+				"dead_letter"      boolean     NOT NULL DEFAULT FALSE,
+				"deadline"         timestamptz,
+				"result"           jsonb,
+				"priority"         integer     NOT NULL DEFAULT 0,
+				"partition"        integer     NOT NULL DEFAULT 0,
+				"log"              jsonb       NOT NULL DEFAULT '[]'
+			)
+		`)
+
+		DeferCleanup(func() { db.MustExec(`DROP TABLE "pee_history_test"`) })
+
+		db.MustExec(`
+			CREATE TABLE "pee_history_test_history" (
+				"instance_id" integer     NOT NULL,
+				"version"     integer     NOT NULL,
+				"state_name"  text        NOT NULL,
+				"state"       jsonb       NOT NULL,
+				"created_at"  timestamptz NOT NULL,
+				PRIMARY KEY ("instance_id", "version")
+			)
+		`)
+
+		DeferCleanup(func() { db.MustExec(`DROP TABLE "pee_history_test_history"`) })
+
+		store = NewPostgresStore("pee_history_test")
+	})
+
+	// state returns a serialized state with the given name, as written by an Automata
+	state := func(name string) []byte {
+		return []byte(fmt.Sprintf(`{"state": %q, "data": {}}`, name))
+	}
+
+	It("migrates the log column into the history table", func() {
+		names := []string{"A", "B", "C", "D"}
+
+		var id pee.InstanceId
+
+		// record the previous states in the log column
+		err := ql.InNewTransaction(ctx, db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: state(names[0]), StateName: names[0]})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, name := range names[1:] {
+				instance.State, instance.StateName = state(name), name
+
+				instance, err = store.Update(ctx, *instance)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			Expect(instance.Version).To(Equal(len(names)))
+
+			id = instance.Id
+			return nil
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+
+		history := store
+		history.History = HistoryTable
+
+		var migrated []HistoryEntry
+
+		// the second run finds nothing to migrate
+		for run := 0; run < 2; run++ {
+			err = ql.InNewTransaction(ctx, db, func(ctx ql.TxContext) error {
+				Expect(history.MigrateLogToHistory(ctx)).To(Succeed())
+
+				entries, err := history.LoadHistory(ctx, id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(len(names)))
+
+				for idx, entry := range entries {
+					Expect(entry.Version).To(Equal(idx + 1))
+					Expect(entry.StateName).To(Equal(names[idx]))
+					Expect(entry.State).To(MatchJSON(state(names[idx])))
+				}
+
+				if migrated != nil {
+					Expect(entries).To(Equal(migrated))
+				}
+
+				migrated = entries
+
+				log, err := ql.Get[string](ctx, `SELECT "log"::text FROM "pee_history_test" WHERE "id"=$1`, string(id))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(*log).To(Equal("[]"))
+
+				return nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,99 @@
+package pee_pg
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"time"
+)
+
+// AcquireSlot takes one of the given number of slots of the key for the owner.
+// Slots are rows in the slot table, which needs the following columns:
+//
+//	"key"        text        NOT NULL,
+//	"slot"       integer     NOT NULL,
+//	"owner"      text        NOT NULL,
+//	"expires_at" timestamptz NOT NULL,
+//	PRIMARY KEY ("key", "slot")
+//
+// The primary key guarantees that concurrent transactions never take the same slot.
+func (s PostgresStore) AcquireSlot(ctx ql.TxContext, key string, slots int, owner string, expiresAt time.Time) (bool, error) {
+	table := s.slotTable()
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("key", "slot", "owner", "expires_at") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("key", "slot") DO UPDATE SET "owner"=$3, "expires_at"=$4 WHERE %q."expires_at" <= $5`, table, table)
+
+	now := time.Now()
+
+	for slot := 0; slot < slots; slot++ {
+		affected, err := ql.ExecAffected(ctx, stmt, key, slot, owner, expiresAt, now)
+		if err != nil {
+			return false, fmt.Errorf("acquire slot %d of %q: %w", slot, key, err)
+		}
+
+		if affected > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s PostgresStore) ReleaseSlot(ctx ql.TxContext, key string, owner string) error {
+	stmt := fmt.Sprintf(`DELETE FROM %q WHERE "key"=$1 AND "owner"=$2`, s.slotTable())
+
+	if err := ql.Exec(ctx, stmt, key, owner); err != nil {
+		return fmt.Errorf("release slot of %q: %w", key, err)
+	}
+
+	return nil
+}
+
+// TakeToken takes a token from the token bucket of the key. Buckets are rows in the
+// bucket table, which needs the following columns:
+//
+//	"key"         text             NOT NULL PRIMARY KEY,
+//	"tokens"      double precision NOT NULL,
+//	"refilled_at" bigint           NOT NULL -- unix time in nanoseconds
+//
+// The bucket is refilled and a token is taken by a single update of the row.
+func (s PostgresStore) TakeToken(ctx ql.TxContext, key string, limit int, per time.Duration) (bool, error) {
+	table := s.bucketTable()
+	now := time.Now().UnixNano()
+
+	// a new bucket is full
+	stmt := fmt.Sprintf(`INSERT INTO %q ("key", "tokens", "refilled_at") VALUES ($1, $2, $3) ON CONFLICT ("key") DO NOTHING`, table)
+	if err := ql.Exec(ctx, stmt, key, float64(limit), now); err != nil {
+		return false, fmt.Errorf("create bucket %q: %w", key, err)
+	}
+
+	// the number of tokens after refilling the bucket for the time since the last refill
+	refilled := `"tokens" + CAST($2 - "refilled_at" AS double precision) * CAST($3 AS double precision)`
+
+	stmt = fmt.Sprintf(`UPDATE %q SET "tokens"=(CASE WHEN %s > $4 THEN $4 ELSE %s END) - 1, "refilled_at"=$2 WHERE "key"=$1 AND %s >= 1`,
+		table, refilled, refilled, refilled)
+
+	rate := float64(limit) / float64(per.Nanoseconds())
+
+	affected, err := ql.ExecAffected(ctx, stmt, key, now, rate, float64(limit))
+	if err != nil {
+		return false, fmt.Errorf("take token of %q: %w", key, err)
+	}
+
+	return affected > 0, nil
+}
+
+func (s PostgresStore) slotTable() string {
+	if s.SlotTableName != "" {
+		return s.SlotTableName
+	}
+
+	return s.Table + "_slots"
+}
+
+func (s PostgresStore) bucketTable() string {
+	if s.BucketTableName != "" {
+		return s.BucketTableName
+	}
+
+	return s.Table + "_buckets"
+}
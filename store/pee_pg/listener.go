@@ -0,0 +1,110 @@
+package pee_pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"pee"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedDriver is returned by Listener.Listen if the database does not use the pgx driver.
+var ErrUnsupportedDriver = errors.New("listener requires the pgx driver")
+
+// Listener receives the notifications sent by a PostgresStore with a NotifyChannel
+// using LISTEN and delivers the ids of the changed instances to its subscribers.
+// It implements pee.Notifier, see pee.Automata.WithNotifier.
+//
+// The database must use the pgx driver, see github.com/jackc/pgx/v5/stdlib.
+type Listener struct {
+	*pee.Broadcaster
+
+	db      *sql.DB
+	channel string
+}
+
+// NewListener creates a new Listener for the given notification channel.
+// Notifications are only received while Listen is running.
+func NewListener(db *sql.DB, channel string) *Listener {
+	return &Listener{
+		Broadcaster: pee.NewBroadcaster(),
+		db:          db,
+		channel:     channel,
+	}
+}
+
+// Listen listens for notifications until the given context is done. Listen uses a
+// dedicated connection of the database that is re-established after a short delay,
+// if it fails. Listen returns the error of the context.
+func (l *Listener) Listen(ctx context.Context) error {
+	for {
+		err := l.listen(ctx)
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+
+		case errors.Is(err, ErrUnsupportedDriver):
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("%w, got %T", ErrUnsupportedDriver, driverConn)
+		}
+
+		pgxConn := stdlibConn.Conn()
+
+		if _, err := pgxConn.Exec(ctx, "LISTEN "+pgx.Identifier{l.channel}.Sanitize()); err != nil {
+			return fmt.Errorf("listen on channel %q: %w", l.channel, err)
+		}
+
+		// the connection is returned to the pool afterwards and must not receive notifications anymore
+		defer func() { _, _ = pgxConn.Exec(context.Background(), "UNLISTEN *") }()
+
+		return l.receive(ctx, pgxConn)
+	})
+}
+
+// notificationSource is a connection that receives notifications, e.g. a *pgx.Conn.
+type notificationSource interface {
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+}
+
+// receive publishes the ids of the changed instances received from the source until
+// waiting for a notification fails. Notifications without an id are ignored.
+func (l *Listener) receive(ctx context.Context, source notificationSource) error {
+	for {
+		notification, err := source.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+
+		if id := strings.TrimSpace(notification.Payload); id != "" {
+			l.Publish(pee.InstanceId(id))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pee_pg
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"time"
+)
+
+// Heartbeat registers the worker as a member of the group. Members are rows in
+// the member table, which needs the following columns:
+//
+//	"group"      text        NOT NULL,
+//	"worker"     text        NOT NULL,
+//	"expires_at" timestamptz NOT NULL,
+//	PRIMARY KEY ("group", "worker")
+//
+// Expired members are removed. If the store has a Type, the group is prefixed with the
+// Type, so that runners of different types sharing the same tables form separate groups.
+func (s PostgresStore) Heartbeat(ctx ql.TxContext, group string, worker string, expiresAt time.Time) ([]string, error) {
+	table := s.memberTable()
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("group", "worker", "expires_at") VALUES ($1, $2, $3)
+		ON CONFLICT ("group", "worker") DO UPDATE SET "expires_at"=$3`, table)
+
+	if err := ql.Exec(ctx, stmt, s.groupKey(group), worker, expiresAt); err != nil {
+		return nil, fmt.Errorf("register worker %q in group %q: %w", worker, group, err)
+	}
+
+	stmt = fmt.Sprintf(`DELETE FROM %q WHERE "group"=$1 AND "expires_at" <= $2`, table)
+	if err := ql.Exec(ctx, stmt, s.groupKey(group), time.Now()); err != nil {
+		return nil, fmt.Errorf("remove expired members of group %q: %w", group, err)
+	}
+
+	query := fmt.Sprintf(`SELECT "worker" FROM %q WHERE "group"=$1 ORDER BY "worker"`, table)
+
+	members, err := ql.Select[string](ctx, query, s.groupKey(group))
+	if err != nil {
+		return nil, fmt.Errorf("list members of group %q: %w", group, err)
+	}
+
+	return members, nil
+}
+
+func (s PostgresStore) Leave(ctx ql.TxContext, group string, worker string) error {
+	stmt := fmt.Sprintf(`DELETE FROM %q WHERE "group"=$1 AND "worker"=$2`, s.partitionTable())
+	if err := ql.Exec(ctx, stmt, s.groupKey(group), worker); err != nil {
+		return fmt.Errorf("release partitions of worker %q: %w", worker, err)
+	}
+
+	stmt = fmt.Sprintf(`DELETE FROM %q WHERE "group"=$1 AND "worker"=$2`, s.memberTable())
+	if err := ql.Exec(ctx, stmt, s.groupKey(group), worker); err != nil {
+		return fmt.Errorf("remove worker %q from group %q: %w", worker, group, err)
+	}
+
+	return nil
+}
+
+// ClaimPartition claims the partition of the group for the worker. Claims are rows in
+// the partition table, which needs the following columns:
+//
+//	"group"      text        NOT NULL,
+//	"partition"  integer     NOT NULL,
+//	"worker"     text        NOT NULL,
+//	"expires_at" timestamptz NOT NULL,
+//	PRIMARY KEY ("group", "partition")
+//
+// The group is scoped by the Type of the store, see PostgresStore.Heartbeat.
+func (s PostgresStore) ClaimPartition(ctx ql.TxContext, group string, partition int, worker string, expiresAt time.Time) (bool, error) {
+	table := s.partitionTable()
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("group", "partition", "worker", "expires_at") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("group", "partition") DO UPDATE SET "worker"=$3, "expires_at"=$4
+		WHERE %q."worker"=$3 OR %q."expires_at" <= $5`, table, table, table)
+
+	affected, err := ql.ExecAffected(ctx, stmt, s.groupKey(group), partition, worker, expiresAt, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("claim partition %d of group %q: %w", partition, group, err)
+	}
+
+	return affected > 0, nil
+}
+
+func (s PostgresStore) ReleasePartition(ctx ql.TxContext, group string, partition int, worker string) error {
+	stmt := fmt.Sprintf(`DELETE FROM %q WHERE "group"=$1 AND "partition"=$2 AND "worker"=$3`, s.partitionTable())
+
+	if err := ql.Exec(ctx, stmt, s.groupKey(group), partition, worker); err != nil {
+		return fmt.Errorf("release partition %d of group %q: %w", partition, group, err)
+	}
+
+	return nil
+}
+
+// groupKey returns the key of the group in the member and partition tables.
+func (s PostgresStore) groupKey(group string) string {
+	if s.Type == "" {
+		return group
+	}
+
+	return s.Type + "/" + group
+}
+
+func (s PostgresStore) memberTable() string {
+	if s.MemberTableName != "" {
+		return s.MemberTableName
+	}
+
+	return s.Table + "_members"
+}
+
+func (s PostgresStore) partitionTable() string {
+	if s.PartitionTableName != "" {
+		return s.PartitionTableName
+	}
+
+	return s.Table + "_partitions"
+}
@@ -0,0 +1,61 @@
+package pee_pg
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"pee"
+	"time"
+)
+
+// LoadSchedule loads the schedule with the given name. Schedules are rows in
+// the schedule table, which needs the following columns:
+//
+//	"name"      text        NOT NULL PRIMARY KEY,
+//	"last_tick" timestamptz NOT NULL,
+//	"version"   integer     NOT NULL
+func (s PostgresStore) LoadSchedule(ctx ql.TxContext, name string, lastTick time.Time) (*pee.SerializedSchedule, error) {
+	table := s.scheduleTable()
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("name", "last_tick", "version") VALUES ($1, $2, 1) ON CONFLICT ("name") DO NOTHING`, table)
+	if err := ql.Exec(ctx, stmt, name, lastTick); err != nil {
+		return nil, fmt.Errorf("create schedule %q: %w", name, err)
+	}
+
+	type dbSchedule struct {
+		Name     string    `db:"name"`
+		LastTick time.Time `db:"last_tick"`
+		Version  int       `db:"version"`
+	}
+
+	query := fmt.Sprintf(`SELECT "name", "last_tick", "version" FROM %q WHERE "name"=$1`, table)
+
+	row, err := ql.Get[dbSchedule](ctx, query, name)
+	if err != nil {
+		return nil, fmt.Errorf("load schedule %q: %w", name, err)
+	}
+
+	return &pee.SerializedSchedule{Name: row.Name, LastTick: row.LastTick, Version: row.Version}, nil
+}
+
+func (s PostgresStore) UpdateSchedule(ctx ql.TxContext, name string, version int, lastTick time.Time) error {
+	stmt := fmt.Sprintf(`UPDATE %q SET "last_tick"=$3, "version"=$2+1 WHERE "name"=$1 AND "version"=$2`, s.scheduleTable())
+
+	affected, err := ql.ExecAffected(ctx, stmt, name, version, lastTick)
+	if err != nil {
+		return fmt.Errorf("update schedule %q: %w", name, err)
+	}
+
+	if affected == 0 {
+		return pee.ErrOptimisticLocking
+	}
+
+	return nil
+}
+
+func (s PostgresStore) scheduleTable() string {
+	if s.ScheduleTableName != "" {
+		return s.ScheduleTableName
+	}
+
+	return s.Table + "_schedules"
+}
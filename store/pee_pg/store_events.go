@@ -0,0 +1,184 @@
+package pee_pg
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"pee"
+	"time"
+)
+
+// DefaultSnapshotInterval is the number of versions between two snapshots of an EventStore.
+const DefaultSnapshotInterval = 10
+
+// EventStore is an event sourced Store. Every state of an instance is appended as an
+// immutable event to the EventsTable. The current state is materialized as a snapshot in
+// the Table every SnapshotInterval versions. Loading an instance reads the last snapshot
+// and applies all events that were appended afterwards.
+//
+// The snapshot Table needs the same columns as the table of a PostgresStore,
+// the "log" column is not required. The EventsTable needs the same columns as a history
+// table, see HistoryTable.
+//
+// The EventStore does not implement pee.ListStore, as snapshots do not reflect the
+// current state of an instance. The trace context, the deadline and the priority of an
+// instance are not part of the events, they are updated in the snapshot row with every
+// version. Failed attempts are not tracked.
+type EventStore struct {
+	// Table is the name of the table holding the snapshots of the instances.
+	Table string
+
+	// EventsTable is the name of the table holding the events.
+	// Defaults to the name of the Table with an "_events" suffix.
+	EventsTable string
+
+	// Type is an optional discriminator, see PostgresStore.Type.
+	Type string
+
+	// SnapshotInterval is the number of versions between two snapshots.
+	// Defaults to DefaultSnapshotInterval.
+	SnapshotInterval int
+
+	// NotifyChannel is an optional channel to notify about changed instances,
+	// see PostgresStore.NotifyChannel.
+	NotifyChannel string
+}
+
+var _ pee.Store[ql.TxContext] = EventStore{}
+
+func (s EventStore) Update(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	now := time.Now()
+
+	// the event can only be appended if the previous version is the latest event. The primary
+	// key on instance_id and version rejects concurrent updates.
+	where := fmt.Sprintf(`EXISTS (SELECT 1 FROM %q WHERE "instance_id"=$1 AND "version"=$2)`, s.eventsTable())
+	args := []any{string(instance.Id), instance.Version, instance.StateName, instance.State, now}
+
+	if s.Type != "" {
+		args = append(args, s.Type)
+		where += fmt.Sprintf(` AND EXISTS (SELECT 1 FROM %q WHERE "id"=$1 AND "type"=$6)`, s.Table)
+	}
+
+	stmt := fmt.Sprintf(`
+		INSERT INTO %q ("instance_id", "version", "state_name", "state", "created_at")
+		SELECT $1, $2+1, $3, $4, $5 WHERE %s
+		ON CONFLICT DO NOTHING`,
+		s.eventsTable(), where)
+
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("append event for %s@%d: %w", instance.Id, instance.Version, err)
+	}
+
+	if affected == 0 {
+		return nil, pee.ErrOptimisticLocking
+	}
+
+	instance.Version = instance.Version + 1
+	instance.UpdatedAt = now
+
+	traceContext, err := marshalMap(instance.TraceContext)
+	if err != nil {
+		return nil, fmt.Errorf("serialize trace context: %w", err)
+	}
+
+	// the metadata is not part of the events, it is kept up to date in the snapshot row
+	set := `"trace_context"=$2, "deadline"=$3, "priority"=$4, ` + clearFailure
+	args = []any{string(instance.Id), traceContext, nullTime(instance.Deadline), instance.Priority}
+
+	if instance.Version%s.snapshotInterval() == 0 {
+		set += `, "version"=$5, "state"=$6, "state_name"=$7, "updated_at"=$8`
+		args = append(args, instance.Version, instance.State, instance.StateName, now)
+	}
+
+	stmt = fmt.Sprintf(`UPDATE %q SET %s WHERE "id"=$1`, s.Table, set)
+	if err := ql.Exec(ctx, stmt, args...); err != nil {
+		return nil, fmt.Errorf("write snapshot of %s@%d: %w", instance.Id, instance.Version, err)
+	}
+
+	if err := s.snapshots().notify(ctx, instance.Id); err != nil {
+		return nil, err
+	}
+
+	return &instance, nil
+}
+
+func (s EventStore) Create(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	// the first snapshot is written right away and also assigns the id
+	created, err := s.snapshots().Create(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("instance_id", "version", "state_name", "state", "created_at") VALUES ($1, $2, $3, $4, $5)`, s.eventsTable())
+
+	err = ql.Exec(ctx, stmt, string(created.Id), created.Version, created.StateName, created.State, created.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("append initial event for %s: %w", created.Id, err)
+	}
+
+	return created, nil
+}
+
+func (s EventStore) Load(ctx ql.TxContext, id pee.InstanceId) (*pee.SerializedInstance, error) {
+	instance, err := s.snapshots().Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.loadEvents(ctx, id, instance.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// apply all events after the snapshot
+	for _, event := range events {
+		instance.Version = event.Version
+		instance.State = event.State
+		instance.StateName = event.StateName
+		instance.UpdatedAt = event.CreatedAt
+	}
+
+	return instance, nil
+}
+
+// LoadEvents returns all events of the instance with the given id, oldest events first.
+// This can be used to replay the exact sequence of states of an instance.
+func (s EventStore) LoadEvents(ctx ql.TxContext, id pee.InstanceId) ([]HistoryEntry, error) {
+	// verify that the instance exists and has the right type
+	if _, err := s.snapshots().Load(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return s.loadEvents(ctx, id, 0)
+}
+
+func (s EventStore) loadEvents(ctx ql.TxContext, id pee.InstanceId, afterVersion int) ([]HistoryEntry, error) {
+	query := fmt.Sprintf(`SELECT "instance_id", "version", "state_name", "state", "created_at" FROM %q WHERE "instance_id"=$1 AND "version">$2 ORDER BY "version"`, s.eventsTable())
+
+	events, err := ql.Select[HistoryEntry](ctx, query, string(id), afterVersion)
+	if err != nil {
+		return nil, fmt.Errorf("load events of instance id=%s: %w", id, err)
+	}
+
+	return events, nil
+}
+
+func (s EventStore) snapshots() PostgresStore {
+	return PostgresStore{Table: s.Table, Type: s.Type, History: HistoryNone, NotifyChannel: s.NotifyChannel}
+}
+
+func (s EventStore) eventsTable() string {
+	if s.EventsTable != "" {
+		return s.EventsTable
+	}
+
+	return s.Table + "_events"
+}
+
+func (s EventStore) snapshotInterval() int {
+	if s.SnapshotInterval > 0 {
+		return s.SnapshotInterval
+	}
+
+	return DefaultSnapshotInterval
+}
@@ -2,75 +2,552 @@ package pee_pg
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/flachnetz/startup/v2/lib/ql"
 	"pee"
+	"strings"
+	"time"
 )
 
-type PostgresStore string
+// PostgresStore stores instances in the table with the given name.
+// If an instance is created without an id, the database needs to assign one, e.g.
+// using a serial column. Ids are passed as strings to the database, so the id
+// column can be of any type that can be parsed from its text representation.
+// The table needs to have the following columns:
+//
+//	"id"               serial      NOT NULL PRIMARY KEY, -- or text/uuid for client generated ids
+//	"version"          integer     NOT NULL,
+//	"state"            jsonb       NOT NULL,
+//	"state_name"       text        NOT NULL,
+//	"labels"           jsonb,
+//	"created_at"       timestamptz NOT NULL,
+//	"updated_at"       timestamptz NOT NULL,
+//	"trace_context"    jsonb,
+//	"attempts"         integer     NOT NULL DEFAULT 0,
+//	"last_error"       text,
+//	"last_error_stack" text,
+//	"dead_letter"      boolean     NOT NULL DEFAULT FALSE,
+//	"deadline"         timestamptz,
+//	"result"           jsonb,
+//	"priority"         integer     NOT NULL DEFAULT 0,
+//	"partition"        integer     NOT NULL DEFAULT 0,
+//	"log"              jsonb       NOT NULL DEFAULT '[]'
+//
+// If the store has a Type, the table also needs a "type" text column.
+// The "log" column is only required when using HistoryLog, see HistoryMode for details.
+type PostgresStore struct {
+	// Table is the name of the table holding the instances.
+	Table string
 
-func (s PostgresStore) Update(ctx ql.TxContext, id, version int, newState []byte) (*pee.SerializedInstance, error) {
-	stmt := fmt.Sprintf(`UPDATE %q SET "log"=("log"::jsonb || "state"::jsonb), "state"=$3, "version"=$2+1 WHERE "id"=$1 AND "version"=$2`, string(s))
-	affected, err := ql.ExecAffected(ctx, stmt, id, version, newState)
+	// Type is an optional discriminator, e.g. the name of the Automata. If set,
+	// multiple automata types can share the same table. The store only loads,
+	// updates and lists instances of its own type. Runner groups and their claims
+	// on partitions are separated by the type, too.
+	Type string
+
+	// History configures how previous states of an instance are recorded.
+	// Defaults to HistoryLog.
+	History HistoryMode
+
+	// HistoryTableName is the name of the history table used with HistoryTable mode.
+	// Defaults to the name of the Table with a "_history" suffix.
+	HistoryTableName string
+
+	// HistoryLimit is the maximum number of entries to keep per instance in the
+	// history table. Older entries are pruned when an instance is updated.
+	// Keeps all entries if zero.
+	HistoryLimit int
+
+	// NotifyChannel is the name of a channel to send a notification to, whenever an
+	// instance is created, updated or re-driven. The payload of the notification is the
+	// id of the instance. The notification is sent within the transaction of the change,
+	// so it is only delivered once the transaction commits, see Listener.
+	NotifyChannel string
+
+	// SlotTableName is the name of the table holding the slots of shared concurrency limits.
+	// Defaults to the name of the Table with a "_slots" suffix. See PostgresStore.AcquireSlot.
+	SlotTableName string
+
+	// BucketTableName is the name of the table holding the token buckets of shared rate limits.
+	// Defaults to the name of the Table with a "_buckets" suffix. See PostgresStore.TakeToken.
+	BucketTableName string
+
+	// MemberTableName is the name of the table holding the members of runner groups.
+	// Defaults to the name of the Table with a "_members" suffix. See PostgresStore.Heartbeat.
+	MemberTableName string
+
+	// PartitionTableName is the name of the table holding the claims of runners on partitions.
+	// Defaults to the name of the Table with a "_partitions" suffix. See PostgresStore.ClaimPartition.
+	PartitionTableName string
+
+	// ScheduleTableName is the name of the table holding the schedules of a Scheduler.
+	// Defaults to the name of the Table with a "_schedules" suffix. See PostgresStore.LoadSchedule.
+	ScheduleTableName string
+
+	// CreateBatchSize is the maximum number of instances inserted with a single statement
+	// by CreateMany. Defaults to DefaultCreateBatchSize.
+	CreateBatchSize int
+}
+
+// NewPostgresStore creates a PostgresStore for the table with the given name, using the
+// defaults for all other options.
+func NewPostgresStore(table string) PostgresStore {
+	return PostgresStore{Table: table}
+}
+
+var _ pee.Store[ql.TxContext] = PostgresStore{}
+var _ pee.ListStore[ql.TxContext] = PostgresStore{}
+var _ pee.CountStore[ql.TxContext] = PostgresStore{}
+var _ pee.FailureStore[ql.TxContext] = PostgresStore{}
+var _ pee.PurgeStore[ql.TxContext] = PostgresStore{}
+var _ pee.ResultStore[ql.TxContext] = PostgresStore{}
+var _ pee.LimitStore[ql.TxContext] = PostgresStore{}
+var _ pee.PartitionStore[ql.TxContext] = PostgresStore{}
+var _ pee.ScheduleStore[ql.TxContext] = PostgresStore{}
+
+func (s PostgresStore) Update(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	now := time.Now()
+
+	traceContext, err := marshalMap(instance.TraceContext)
+	if err != nil {
+		return nil, fmt.Errorf("serialize trace context: %w", err)
+	}
+
+	where, args := s.scope(`"id"=$1 AND "version"=$2`, string(instance.Id), instance.Version, instance.State, instance.StateName, now, traceContext, nullTime(instance.Deadline), instance.Priority)
+
+	set := `"state"=$3, "state_name"=$4, "updated_at"=$5, "trace_context"=$6, "deadline"=$7, "priority"=$8, "version"=$2+1, ` + clearFailure
+	if s.History == HistoryLog {
+		set = `"log"=("log"::jsonb || "state"::jsonb), ` + set
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %q SET %s WHERE %s`, s.Table, set, where)
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
 
 	if err != nil {
-		return nil, fmt.Errorf("update automat %d@%d in database: %w", id, version, err)
+		return nil, fmt.Errorf("update automat %s@%d in database: %w", instance.Id, instance.Version, err)
 	}
 
 	if affected == 0 {
 		return nil, pee.ErrOptimisticLocking
 	}
 
-	serializedInstance := &pee.SerializedInstance{
-		Id:      id,
-		Version: version + 1,
-		State:   newState,
+	instance.Version = instance.Version + 1
+	instance.UpdatedAt = now
+	instance.Attempts = 0
+	instance.LastError = ""
+	instance.LastErrorStack = ""
+	instance.DeadLetter = false
+
+	if err := s.recordHistory(ctx, instance); err != nil {
+		return nil, err
+	}
+
+	if err := s.notify(ctx, instance.Id); err != nil {
+		return nil, err
 	}
 
-	return serializedInstance, nil
+	return &instance, nil
 }
 
-func (s PostgresStore) Create(ctx ql.TxContext, state []byte) (*pee.SerializedInstance, error) {
-	stmt := fmt.Sprintf(`INSERT INTO %q ("version", "state") VALUES (1, $1) RETURNING id`, string(s))
+func (s PostgresStore) Create(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	created, err := s.insert(ctx, instance, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordHistory(ctx, created); err != nil {
+		return nil, err
+	}
+
+	if err := s.notify(ctx, created.Id); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
 
-	id, err := ql.Get[int](ctx, stmt, state)
+// insert inserts a single new instance and returns it with the id of the inserted row.
+func (s PostgresStore) insert(ctx ql.TxContext, instance pee.SerializedInstance, now time.Time) (pee.SerializedInstance, error) {
+	columns, values, err := s.insertValues(instance, now)
 	if err != nil {
-		return nil, fmt.Errorf("insert: %w", err)
+		return pee.SerializedInstance{}, err
 	}
 
-	serializedInstance := &pee.SerializedInstance{
-		Id:      *id,
-		Version: 1,
-		State:   state,
+	var placeholders []string
+	for idx := range columns {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", idx+1))
 	}
 
-	return serializedInstance, nil
+	stmt := fmt.Sprintf(`INSERT INTO %q (%s) VALUES (%s) RETURNING "id"`, s.Table,
+		strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	// the database might assign a new id if the instance has none yet
+	id, err := ql.Get[string](ctx, stmt, values...)
+	if err != nil {
+		return pee.SerializedInstance{}, fmt.Errorf("insert: %w", err)
+	}
+
+	return createdInstance(instance, pee.InstanceId(*id), now), nil
 }
 
-func (s PostgresStore) Load(ctx ql.TxContext, id int) (*pee.SerializedInstance, error) {
-	query := fmt.Sprintf(`SELECT "id", "version", "state" FROM %q WHERE "id"=$1`, string(s))
+// insertValues returns the quoted columns and the values to insert for a new instance.
+// The id is only included, if the instance has one.
+func (s PostgresStore) insertValues(instance pee.SerializedInstance, now time.Time) ([]string, []any, error) {
+	labels, err := marshalMap(instance.Labels)
+	if err != nil {
+		return nil, nil, fmt.Errorf("serialize labels: %w", err)
+	}
+
+	traceContext, err := marshalMap(instance.TraceContext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("serialize trace context: %w", err)
+	}
+
+	columns := []string{"version", "state", "state_name", "labels", "created_at", "updated_at", "trace_context", "attempts", "deadline", "priority", "partition"}
+	values := []any{1, instance.State, instance.StateName, labels, now, now, traceContext, 0, nullTime(instance.Deadline), instance.Priority, instance.Partition}
+
+	if instance.Id != "" {
+		columns = append(columns, "id")
+		values = append(values, string(instance.Id))
+	}
+
+	if s.Type != "" {
+		columns = append(columns, "type")
+		values = append(values, s.Type)
+	}
 
-	type dbInstance struct {
-		Id      int    `db:"id"`
-		Version int    `db:"version"`
-		State   []byte `db:"state"`
+	for idx := range columns {
+		columns[idx] = fmt.Sprintf("%q", columns[idx])
 	}
 
-	row, err := ql.Get[dbInstance](ctx, query, id)
+	return columns, values, nil
+}
+
+// createdInstance returns the given instance as it was created with the given id.
+func createdInstance(instance pee.SerializedInstance, id pee.InstanceId, now time.Time) pee.SerializedInstance {
+	instance.Id = id
+	instance.Version = 1
+	instance.CreatedAt = now
+	instance.UpdatedAt = now
+	instance.Attempts = 0
+	instance.LastError = ""
+	instance.LastErrorStack = ""
+	instance.DeadLetter = false
+
+	return instance
+}
+
+func (s PostgresStore) Load(ctx ql.TxContext, id pee.InstanceId) (*pee.SerializedInstance, error) {
+	where, args := s.scope(`"id"=$1`, string(id))
+
+	query := fmt.Sprintf(`SELECT %s FROM %q WHERE %s`, selectColumns, s.Table, where)
+
+	row, err := ql.Get[dbInstance](ctx, query, args...)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
-		return nil, fmt.Errorf("loading instance id=%d: %w", id, pee.ErrNoSuchInstance)
+		return nil, fmt.Errorf("loading instance id=%s: %w", id, pee.ErrNoSuchInstance)
 
 	case err != nil:
 		return nil, fmt.Errorf("loading automat: %w", err)
 	}
 
+	return row.toSerializedInstance()
+}
+
+func (s PostgresStore) List(ctx ql.TxContext, query pee.ListQuery) ([]*pee.SerializedInstance, error) {
+	where, args := s.scope(`TRUE`)
+
+	if len(query.StateNames) > 0 {
+		where, args = inStates(where, args, query.StateNames)
+	}
+
+	if len(query.Partitions) > 0 {
+		where, args = inPartitions(where, args, query.Partitions)
+	}
+
+	switch query.DeadLetter {
+	case pee.ExcludeDeadLetters:
+		where += ` AND NOT "dead_letter"`
+	case pee.OnlyDeadLetters:
+		where += ` AND "dead_letter"`
+	}
+
+	orderBy := `"created_at", "id"`
+	if query.Order == pee.OrderByPriority {
+		orderBy = `"priority" DESC, "created_at", "id"`
+	}
+
+	stmt := fmt.Sprintf(`SELECT %s FROM %q WHERE %s ORDER BY %s`, selectColumns, s.Table, where, orderBy)
+
+	if query.FairnessLabel != "" {
+		// rank the instances per label value to interleave them round robin
+		args = append(args, query.FairnessLabel)
+
+		stmt = fmt.Sprintf(`
+			SELECT %s FROM (
+				SELECT *, ROW_NUMBER() OVER (PARTITION BY "labels"->>CAST($%d AS text) ORDER BY %s) AS "fair_rank"
+				FROM %q WHERE %s
+			) AS "ranked"
+			ORDER BY "fair_rank", %s`,
+			selectColumns, len(args), orderBy, s.Table, where, orderBy)
+	}
+
+	if query.Limit > 0 {
+		stmt += fmt.Sprintf(` LIMIT %d`, query.Limit)
+	}
+
+	rows, err := ql.Select[dbInstance](ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list instances: %w", err)
+	}
+
+	instances := make([]*pee.SerializedInstance, 0, len(rows))
+
+	for _, row := range rows {
+		instance, err := row.toSerializedInstance()
+		if err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+func (s PostgresStore) RecordFailure(ctx ql.TxContext, id pee.InstanceId, version int, failure pee.Failure) error {
+	where, args := s.scope(`"id"=$1 AND "version"=$2`, string(id), version, failure.Error, nullString(failure.Stack), failure.DeadLetter)
+
+	stmt := fmt.Sprintf(`UPDATE %q SET "attempts"="attempts"+1, "last_error"=$3, "last_error_stack"=$4, "dead_letter"=$5 WHERE %s`, s.Table, where)
+
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("record failure of %s@%d: %w", id, version, err)
+	}
+
+	if affected == 0 {
+		return pee.ErrOptimisticLocking
+	}
+
+	return nil
+}
+
+func (s PostgresStore) ClearFailure(ctx ql.TxContext, id pee.InstanceId, version int) error {
+	where, args := s.scope(`"id"=$1 AND "version"=$2`, string(id), version)
+
+	stmt := fmt.Sprintf(`UPDATE %q SET %s WHERE %s`, s.Table, clearFailure, where)
+
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("clear failure of %s@%d: %w", id, version, err)
+	}
+
+	if affected == 0 {
+		return pee.ErrOptimisticLocking
+	}
+
+	return s.notify(ctx, id)
+}
+
+func (s PostgresStore) StoreResult(ctx ql.TxContext, id pee.InstanceId, version int, result []byte) error {
+	where, args := s.scope(`"id"=$1 AND "version"=$2`, string(id), version, result)
+
+	stmt := fmt.Sprintf(`UPDATE %q SET "result"=$3 WHERE %s`, s.Table, where)
+
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("store result of %s@%d: %w", id, version, err)
+	}
+
+	if affected == 0 {
+		return pee.ErrOptimisticLocking
+	}
+
+	return nil
+}
+
+func (s PostgresStore) Purge(ctx ql.TxContext, query pee.PurgeQuery) (int, error) {
+	where, args := s.scope(`"updated_at" < $1`, query.UpdatedBefore)
+	where, args = inStates(where, args, query.StateNames)
+
+	if s.History == HistoryTable {
+		stmt := fmt.Sprintf(`DELETE FROM %q WHERE "instance_id" IN (SELECT "id" FROM %q WHERE %s)`, s.historyTable(), s.Table, where)
+		if err := ql.Exec(ctx, stmt, args...); err != nil {
+			return 0, fmt.Errorf("purge history: %w", err)
+		}
+	}
+
+	stmt := fmt.Sprintf(`DELETE FROM %q WHERE %s`, s.Table, where)
+
+	affected, err := ql.ExecAffected(ctx, stmt, args...)
+	if err != nil {
+		return 0, fmt.Errorf("purge instances: %w", err)
+	}
+
+	return int(affected), nil
+}
+
+func (s PostgresStore) CountByState(ctx ql.TxContext) (map[string]int, error) {
+	where, args := s.scope(`TRUE`)
+
+	type stateCount struct {
+		StateName string `db:"state_name"`
+		Count     int    `db:"count"`
+	}
+
+	stmt := fmt.Sprintf(`SELECT "state_name", COUNT(*) AS "count" FROM %q WHERE %s GROUP BY "state_name"`, s.Table, where)
+
+	rows, err := ql.Select[stateCount](ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("count instances: %w", err)
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.StateName] = row.Count
+	}
+
+	return counts, nil
+}
+
+// notify sends a notification with the given id to the NotifyChannel, if configured.
+func (s PostgresStore) notify(ctx ql.TxContext, id pee.InstanceId) error {
+	if s.NotifyChannel == "" {
+		return nil
+	}
+
+	if err := ql.Exec(ctx, `SELECT pg_notify($1, $2)`, s.NotifyChannel, string(id)); err != nil {
+		return fmt.Errorf("notify channel %q: %w", s.NotifyChannel, err)
+	}
+
+	return nil
+}
+
+// scope adds the discriminator condition to the given where clause if the store has a Type.
+// The type is appended to the given arguments.
+func (s PostgresStore) scope(where string, args ...any) (string, []any) {
+	if s.Type == "" {
+		return where, args
+	}
+
+	args = append(args, s.Type)
+	return fmt.Sprintf(`%s AND "type"=$%d`, where, len(args)), args
+}
+
+// inStates adds a condition on the state name to the given where clause.
+// The state names are appended to the given arguments.
+func inStates(where string, args []any, stateNames []string) (string, []any) {
+	var placeholders []string
+	for _, name := range stateNames {
+		args = append(args, name)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	return fmt.Sprintf(`%s AND "state_name" IN (%s)`, where, strings.Join(placeholders, ", ")), args
+}
+
+// inPartitions adds a condition on the partition to the given where clause.
+// The partitions are appended to the given arguments.
+func inPartitions(where string, args []any, partitions []int) (string, []any) {
+	var placeholders []string
+	for _, partition := range partitions {
+		args = append(args, partition)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	return fmt.Sprintf(`%s AND "partition" IN (%s)`, where, strings.Join(placeholders, ", ")), args
+}
+
+const selectColumns = `"id", "version", "state", "state_name", "labels", "created_at", "updated_at", "trace_context", "attempts", "last_error", "last_error_stack", "dead_letter", "deadline", "result", "priority", "partition"`
+
+// clearFailure resets the failed attempts of an instance.
+const clearFailure = `"attempts"=0, "last_error"=NULL, "last_error_stack"=NULL, "dead_letter"=FALSE`
+
+type dbInstance struct {
+	Id        string    `db:"id"`
+	Version   int       `db:"version"`
+	State     []byte    `db:"state"`
+	StateName string    `db:"state_name"`
+	Labels    []byte    `db:"labels"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+
+	TraceContext []byte `db:"trace_context"`
+	Attempts     int    `db:"attempts"`
+
+	LastError      sql.NullString `db:"last_error"`
+	LastErrorStack sql.NullString `db:"last_error_stack"`
+	DeadLetter     bool           `db:"dead_letter"`
+	Deadline       sql.NullTime   `db:"deadline"`
+	Result         []byte         `db:"result"`
+	Priority       int            `db:"priority"`
+	Partition      int            `db:"partition"`
+}
+
+func (row dbInstance) toSerializedInstance() (*pee.SerializedInstance, error) {
+	labels, err := unmarshalMap(row.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize labels of instance id=%s: %w", row.Id, err)
+	}
+
+	traceContext, err := unmarshalMap(row.TraceContext)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize trace context of instance id=%s: %w", row.Id, err)
+	}
+
 	instance := &pee.SerializedInstance{
-		Id:      row.Id,
-		Version: row.Version,
-		State:   row.State,
+		Id:        pee.InstanceId(row.Id),
+		Version:   row.Version,
+		State:     row.State,
+		StateName: row.StateName,
+		Labels:    labels,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+		Attempts:  row.Attempts,
+
+		LastError:      row.LastError.String,
+		LastErrorStack: row.LastErrorStack.String,
+		DeadLetter:     row.DeadLetter,
+		Deadline:       row.Deadline.Time,
+		Priority:       row.Priority,
+		Partition:      row.Partition,
+
+		TraceContext: traceContext,
+		Result:       row.Result,
 	}
 
 	return instance, nil
 }
+
+// nullString converts an empty string to NULL.
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// nullTime converts a zero time to NULL.
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value, Valid: !value.IsZero()}
+}
+
+// marshalMap serializes a map to json. An empty map is serialized as NULL.
+func marshalMap(values map[string]string) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(values)
+}
+
+func unmarshalMap(serialized []byte) (map[string]string, error) {
+	var values map[string]string
+
+	if len(serialized) > 0 {
+		if err := json.Unmarshal(serialized, &values); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
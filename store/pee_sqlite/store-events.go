@@ -0,0 +1,38 @@
+package pee_sqlite
+
+import (
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"pee"
+	"pee/store/pee_pg"
+)
+
+// EventStore is an event sourced store for sqlite, see pee_pg.EventStore for details.
+type EventStore pee_pg.EventStore
+
+var _ pee.Store[ql.TxContext] = EventStore{}
+
+func (s EventStore) Update(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	return s.eventStore().Update(ctx, instance)
+}
+
+func (s EventStore) Create(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	return s.eventStore().Create(ctx, instance)
+}
+
+func (s EventStore) Load(ctx ql.TxContext, id pee.InstanceId) (*pee.SerializedInstance, error) {
+	return s.eventStore().Load(ctx, id)
+}
+
+// LoadEvents returns all events of an instance, see pee_pg.EventStore.LoadEvents.
+func (s EventStore) LoadEvents(ctx ql.TxContext, id pee.InstanceId) ([]pee_pg.HistoryEntry, error) {
+	return s.eventStore().LoadEvents(ctx, id)
+}
+
+// eventStore returns the pee_pg.EventStore that implements the actual queries.
+// Sqlite does not support notifications, the NotifyChannel is ignored.
+func (s EventStore) eventStore() pee_pg.EventStore {
+	store := pee_pg.EventStore(s)
+	store.NotifyChannel = ""
+
+	return store
+}
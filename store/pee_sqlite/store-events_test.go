@@ -0,0 +1,160 @@
+package pee_sqlite
+
+import (
+	"fmt"
+	"github.com/flachnetz/startup/v2/lib/ql"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"pee"
+	"time"
+)
+
+var _ = Describe("Sqlite event store", func() {
+	var db *sqlx.DB
+	var store EventStore
+
+	BeforeEach(func() {
+		db = sqlx.MustOpen("sqlite", ":memory:")
+		DeferCleanup(db.Close)
+
+		db.MustExec(`
+			CREATE TABLE "my_table" (
+				"id"         integer   NOT NULL PRIMARY KEY,
+				"version"    integer   NOT NULL,
+				"state"      JSON      NOT NULL,
+				"state_name" text      NOT NULL,
+				"labels"     JSON,
+				"created_at" TIMESTAMP NOT NULL,
+				"updated_at" TIMESTAMP NOT NULL,
+				"trace_context" JSON,
+				"attempts"      integer NOT NULL DEFAULT 0,
+				"last_error"       text,
+				"last_error_stack" text,
+				"dead_letter"      boolean NOT NULL DEFAULT FALSE,
+				"deadline"         TIMESTAMP,
+				"result"           JSON,
+				"priority"         integer NOT NULL DEFAULT 0,
+				"partition"        integer NOT NULL DEFAULT 0
+			)
+		`)
+
+		db.MustExec(`
+			CREATE TABLE "my_table_events" (
+				"instance_id" integer   NOT NULL,
+				"version"     integer   NOT NULL,
+				"state_name"  text      NOT NULL,
+				"state"       JSON      NOT NULL,
+				"created_at"  TIMESTAMP NOT NULL,
+				PRIMARY KEY ("instance_id", "version")
+			)
+		`)
+
+		store = EventStore{Table: "my_table", SnapshotInterval: 3}
+	})
+
+	updateTo := func(ctx ql.TxContext, id pee.InstanceId, version int) {
+		for current := 1; current < version; current++ {
+			state := []byte(fmt.Sprintf("v%d", current+1))
+			_, err := store.Update(ctx, pee.SerializedInstance{Id: id, Version: current, State: state, StateName: "B"})
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	It("rebuilds the current state from snapshot and events", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{
+				State:     []byte("v1"),
+				StateName: "A",
+				Labels:    map[string]string{"tenant": "acme"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			updateTo(ctx, instance.Id, 5)
+
+			// the snapshot was taken at version 3
+			snapshot, err := SqliteStore{Table: "my_table"}.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(snapshot.Version).To(Equal(3))
+
+			loaded, err := store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Version).To(Equal(5))
+			Expect(loaded.State).To(Equal([]byte("v5")))
+			Expect(loaded.StateName).To(Equal("B"))
+			Expect(loaded.Labels).To(Equal(map[string]string{"tenant": "acme"}))
+
+			events, err := store.LoadEvents(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(events).To(HaveLen(5))
+
+			for idx, event := range events {
+				Expect(event.Version).To(Equal(idx + 1))
+				Expect(event.State).To(Equal([]byte(fmt.Sprintf("v%d", idx+1))))
+			}
+
+			return nil
+		})
+	})
+
+	It("keeps deadline, priority and trace context current between snapshots", func() {
+		deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("v1"), StateName: "A", Deadline: deadline})
+			Expect(err).ToNot(HaveOccurred())
+
+			loaded, err := store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Deadline).To(BeTemporally("==", deadline))
+
+			// version 2 is not a snapshot
+			_, err = store.Update(ctx, pee.SerializedInstance{
+				Id:           instance.Id,
+				Version:      1,
+				State:        []byte("v2"),
+				StateName:    "B",
+				Priority:     5,
+				TraceContext: map[string]string{"traceparent": "00-trace"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			loaded, err = store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Version).To(Equal(2))
+			Expect(loaded.State).To(Equal([]byte("v2")))
+			Expect(loaded.Deadline.IsZero()).To(BeTrue())
+			Expect(loaded.Priority).To(Equal(5))
+			Expect(loaded.TraceContext).To(Equal(map[string]string{"traceparent": "00-trace"}))
+
+			return nil
+		})
+	})
+
+	It("uses optimistic locking on the version", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("v1"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+
+			updateTo(ctx, instance.Id, 2)
+
+			// version 1 is outdated
+			_, err = store.Update(ctx, pee.SerializedInstance{Id: instance.Id, Version: 1, State: []byte("other"), StateName: "B"})
+			Expect(err).To(MatchError(pee.ErrOptimisticLocking))
+
+			// version 3 does not exist yet
+			_, err = store.Update(ctx, pee.SerializedInstance{Id: instance.Id, Version: 3, State: []byte("other"), StateName: "B"})
+			Expect(err).To(MatchError(pee.ErrOptimisticLocking))
+
+			return nil
+		})
+	})
+
+	It("fails to load unknown instances", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			_, err := store.Load(ctx, "42")
+			Expect(err).To(MatchError(pee.ErrNoSuchInstance))
+			return nil
+		})
+	})
+})
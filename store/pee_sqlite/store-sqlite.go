@@ -1,39 +1,141 @@
 package pee_sqlite
 
 import (
-	"fmt"
 	"github.com/flachnetz/startup/v2/lib/ql"
 	"pee"
 	"pee/store/pee_pg"
+	"time"
 )
 
-type SqliteStore string
+// SqliteStore stores instances in a sqlite table. See pee_pg.PostgresStore
+// for the required columns, the "log" column is not required.
+// Sqlite has no support for the pee_pg.HistoryLog mode, the store records no
+// history at all unless configured to use pee_pg.HistoryTable.
+type SqliteStore pee_pg.PostgresStore
 
-func (s SqliteStore) Update(ctx ql.TxContext, id, version int, newState []byte) (*pee.SerializedInstance, error) {
-	stmt := "UPDATE " + string(s) + " SET state=$3, version=$2+1 WHERE id=$1 AND version=$2"
-	affected, err := ql.ExecAffected(ctx, stmt, id, version, newState)
+// DefaultCreateBatchSize is the default maximum number of instances inserted with a single
+// statement by SqliteStore.CreateMany. It is smaller than pee_pg.DefaultCreateBatchSize, as
+// binding the parameters of a statement gets slow with many parameters in sqlite.
+const DefaultCreateBatchSize = 50
 
-	if err != nil {
-		return nil, fmt.Errorf("update automata %d@%d in database: %w", id, version, err)
-	}
+// NewSqliteStore creates a SqliteStore for the table with the given name, using the
+// defaults for all other options.
+func NewSqliteStore(table string) SqliteStore {
+	return SqliteStore{Table: table}
+}
 
-	if affected == 0 {
-		return nil, pee.ErrOptimisticLocking
-	}
+var _ pee.Store[ql.TxContext] = SqliteStore{}
+var _ pee.ListStore[ql.TxContext] = SqliteStore{}
+var _ pee.CountStore[ql.TxContext] = SqliteStore{}
+var _ pee.FailureStore[ql.TxContext] = SqliteStore{}
+var _ pee.PurgeStore[ql.TxContext] = SqliteStore{}
+var _ pee.ResultStore[ql.TxContext] = SqliteStore{}
+var _ pee.LimitStore[ql.TxContext] = SqliteStore{}
+var _ pee.PartitionStore[ql.TxContext] = SqliteStore{}
+var _ pee.ScheduleStore[ql.TxContext] = SqliteStore{}
+var _ pee.BatchStore[ql.TxContext] = SqliteStore{}
 
-	serializedInstance := &pee.SerializedInstance{
-		Id:      id,
-		Version: version + 1,
-		State:   newState,
-	}
+func (s SqliteStore) Update(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	return s.postgresStore().Update(ctx, instance)
+}
+
+func (s SqliteStore) Create(ctx ql.TxContext, instance pee.SerializedInstance) (*pee.SerializedInstance, error) {
+	return s.postgresStore().Create(ctx, instance)
+}
+
+func (s SqliteStore) CreateMany(ctx ql.TxContext, instances []pee.SerializedInstance) ([]*pee.SerializedInstance, error) {
+	return s.postgresStore().CreateMany(ctx, instances)
+}
+
+func (s SqliteStore) Load(ctx ql.TxContext, id pee.InstanceId) (*pee.SerializedInstance, error) {
+	return s.postgresStore().Load(ctx, id)
+}
+
+func (s SqliteStore) List(ctx ql.TxContext, query pee.ListQuery) ([]*pee.SerializedInstance, error) {
+	return s.postgresStore().List(ctx, query)
+}
+
+func (s SqliteStore) RecordFailure(ctx ql.TxContext, id pee.InstanceId, version int, failure pee.Failure) error {
+	return s.postgresStore().RecordFailure(ctx, id, version, failure)
+}
+
+func (s SqliteStore) ClearFailure(ctx ql.TxContext, id pee.InstanceId, version int) error {
+	return s.postgresStore().ClearFailure(ctx, id, version)
+}
+
+func (s SqliteStore) StoreResult(ctx ql.TxContext, id pee.InstanceId, version int, result []byte) error {
+	return s.postgresStore().StoreResult(ctx, id, version, result)
+}
+
+func (s SqliteStore) Purge(ctx ql.TxContext, query pee.PurgeQuery) (int, error) {
+	return s.postgresStore().Purge(ctx, query)
+}
+
+func (s SqliteStore) AcquireSlot(ctx ql.TxContext, key string, slots int, owner string, expiresAt time.Time) (bool, error) {
+	return s.postgresStore().AcquireSlot(ctx, key, slots, owner, expiresAt)
+}
 
-	return serializedInstance, nil
+func (s SqliteStore) ReleaseSlot(ctx ql.TxContext, key string, owner string) error {
+	return s.postgresStore().ReleaseSlot(ctx, key, owner)
 }
 
-func (s SqliteStore) Create(ctx ql.TxContext, state []byte) (*pee.SerializedInstance, error) {
-	return pee_pg.PostgresStore(s).Create(ctx, state)
+func (s SqliteStore) TakeToken(ctx ql.TxContext, key string, limit int, per time.Duration) (bool, error) {
+	return s.postgresStore().TakeToken(ctx, key, limit, per)
 }
 
-func (s SqliteStore) Load(ctx ql.TxContext, id int) (*pee.SerializedInstance, error) {
-	return pee_pg.PostgresStore(s).Load(ctx, id)
+func (s SqliteStore) Heartbeat(ctx ql.TxContext, group string, worker string, expiresAt time.Time) ([]string, error) {
+	return s.postgresStore().Heartbeat(ctx, group, worker, expiresAt)
+}
+
+func (s SqliteStore) Leave(ctx ql.TxContext, group string, worker string) error {
+	return s.postgresStore().Leave(ctx, group, worker)
+}
+
+func (s SqliteStore) ClaimPartition(ctx ql.TxContext, group string, partition int, worker string, expiresAt time.Time) (bool, error) {
+	return s.postgresStore().ClaimPartition(ctx, group, partition, worker, expiresAt)
+}
+
+func (s SqliteStore) ReleasePartition(ctx ql.TxContext, group string, partition int, worker string) error {
+	return s.postgresStore().ReleasePartition(ctx, group, partition, worker)
+}
+
+func (s SqliteStore) LoadSchedule(ctx ql.TxContext, name string, lastTick time.Time) (*pee.SerializedSchedule, error) {
+	return s.postgresStore().LoadSchedule(ctx, name, lastTick)
+}
+
+func (s SqliteStore) UpdateSchedule(ctx ql.TxContext, name string, version int, lastTick time.Time) error {
+	return s.postgresStore().UpdateSchedule(ctx, name, version, lastTick)
+}
+
+func (s SqliteStore) CountByState(ctx ql.TxContext) (map[string]int, error) {
+	return s.postgresStore().CountByState(ctx)
+}
+
+// LoadHistory returns the recorded states of an instance, see pee_pg.PostgresStore.LoadHistory.
+func (s SqliteStore) LoadHistory(ctx ql.TxContext, id pee.InstanceId) ([]pee_pg.HistoryEntry, error) {
+	return s.postgresStore().LoadHistory(ctx, id)
+}
+
+// PruneHistory removes old history entries, see pee_pg.PostgresStore.PruneHistory.
+func (s SqliteStore) PruneHistory(ctx ql.TxContext, before time.Time) (int, error) {
+	return s.postgresStore().PruneHistory(ctx, before)
+}
+
+// postgresStore returns the PostgresStore that implements the actual queries.
+// The jsonb log is not available in sqlite and is replaced by pee_pg.HistoryNone.
+// Sqlite does not support notifications, the NotifyChannel is ignored.
+// The CreateBatchSize defaults to DefaultCreateBatchSize.
+func (s SqliteStore) postgresStore() pee_pg.PostgresStore {
+	store := pee_pg.PostgresStore(s)
+	if store.History == pee_pg.HistoryLog {
+		store.History = pee_pg.HistoryNone
+	}
+
+	store.NotifyChannel = ""
+
+	if store.CreateBatchSize <= 0 {
+		store.CreateBatchSize = DefaultCreateBatchSize
+	}
+
+	return store
 }
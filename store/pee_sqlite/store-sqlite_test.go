@@ -9,7 +9,9 @@ import (
 	"github.com/onsi/ginkgo/v2/types"
 	. "github.com/onsi/gomega"
 	"pee"
+	"pee/store/pee_pg"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -29,22 +31,35 @@ var _ = Describe("Sqlite store", func() {
 
 		db.MustExec(fmt.Sprintf(`
 			CREATE TABLE "my_table" (
-				"id"         integer  NOT NULL PRIMARY KEY,
-				"version"    integer  NOT NULL,
-				"state"      JSON   NOT NULL
+				"id"         integer   NOT NULL PRIMARY KEY,
+				"version"    integer   NOT NULL,
+				"state"      JSON      NOT NULL,
+				"state_name" text      NOT NULL,
+				"labels"     JSON,
+				"created_at" TIMESTAMP NOT NULL,
+				"updated_at" TIMESTAMP NOT NULL,
+				"trace_context" JSON,
+				"attempts"      integer NOT NULL DEFAULT 0,
+				"last_error"       text,
+				"last_error_stack" text,
+				"dead_letter"      boolean NOT NULL DEFAULT FALSE,
+				"deadline"         TIMESTAMP,
+				"result"           JSON,
+				"priority"         integer NOT NULL DEFAULT 0,
+				"partition"        integer NOT NULL DEFAULT 0
 			)
 		`))
 
-		store = SqliteStore("my_table")
+		store = NewSqliteStore("my_table")
 	})
 
 	It("Should create a new instance", func() {
 		MustTransaction(db, func(ctx ql.TxContext) error {
-			instance, err := store.Create(ctx, []byte("state data"))
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A"})
 
 			Expect(instance, err).ToNot(Equal(
 				pee.SerializedInstance{
-					Id:      1,
+					Id:      "1",
 					Version: 1,
 					State:   []byte("state data"),
 				},
@@ -54,11 +69,11 @@ var _ = Describe("Sqlite store", func() {
 		})
 
 		MustTransaction(db, func(ctx ql.TxContext) error {
-			instance, err := store.Create(ctx, []byte("new data"))
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("new data"), StateName: "A"})
 
 			Expect(instance, err).ToNot(Equal(
 				pee.SerializedInstance{
-					Id:      2,
+					Id:      "2",
 					Version: 1,
 					State:   []byte("new data"),
 				},
@@ -70,16 +85,16 @@ var _ = Describe("Sqlite store", func() {
 
 	It("Load a previously saved instance", func() {
 		MustTransaction(db, func(ctx ql.TxContext) error {
-			_, err := store.Create(ctx, []byte("state data"))
+			_, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A"})
 			return err
 		})
 
 		MustTransaction(db, func(ctx ql.TxContext) error {
-			instance, err := store.Load(ctx, 1)
+			instance, err := store.Load(ctx, "1")
 
 			Expect(instance, err).ToNot(Equal(
 				pee.SerializedInstance{
-					Id:      1,
+					Id:      "1",
 					Version: 1,
 					State:   []byte("state data"),
 				},
@@ -91,15 +106,15 @@ var _ = Describe("Sqlite store", func() {
 
 	It("update an instance if the version matches", func() {
 		MustTransaction(db, func(ctx ql.TxContext) error {
-			instance, err := store.Create(ctx, []byte("state data"))
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A"})
 			Expect(err).ToNot(HaveOccurred())
 
-			instance, err = store.Update(ctx, 1, 1, []byte("second state"))
+			instance, err = store.Update(ctx, pee.SerializedInstance{Id: "1", Version: 1, State: []byte("second state"), StateName: "B"})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(instance, err).ToNot(Equal(
 				pee.SerializedInstance{
-					Id:      1,
+					Id:      "1",
 					Version: 2,
 					State:   []byte("second state"),
 				},
@@ -109,12 +124,12 @@ var _ = Describe("Sqlite store", func() {
 		})
 
 		MustTransaction(db, func(ctx ql.TxContext) error {
-			instance, err := store.Update(ctx, 1, 2, []byte("third state"))
+			instance, err := store.Update(ctx, pee.SerializedInstance{Id: "1", Version: 2, State: []byte("third state"), StateName: "C"})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(instance, err).ToNot(Equal(
 				pee.SerializedInstance{
-					Id:      1,
+					Id:      "1",
 					Version: 3,
 					State:   []byte("third state"),
 				},
@@ -123,6 +138,682 @@ var _ = Describe("Sqlite store", func() {
 			return nil
 		})
 	})
+
+	It("assigns integer ids if the instance has no id", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			first, err := store.Create(ctx, pee.SerializedInstance{State: []byte("first"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.Id).To(Equal(pee.IntId(1)))
+
+			second, err := store.Create(ctx, pee.SerializedInstance{State: []byte("second"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(second.Id).To(Equal(pee.IntId(2)))
+
+			return nil
+		})
+	})
+
+	It("supports client generated ids", func() {
+		db.MustExec(`
+			CREATE TABLE "uuid_table" (
+				"id"         text      NOT NULL PRIMARY KEY,
+				"version"    integer   NOT NULL,
+				"state"      JSON      NOT NULL,
+				"state_name" text      NOT NULL,
+				"labels"     JSON,
+				"created_at" TIMESTAMP NOT NULL,
+				"updated_at" TIMESTAMP NOT NULL,
+				"trace_context" JSON,
+				"attempts"      integer NOT NULL DEFAULT 0,
+				"last_error"       text,
+				"last_error_stack" text,
+				"dead_letter"      boolean NOT NULL DEFAULT FALSE,
+				"deadline"         TIMESTAMP,
+				"result"           JSON,
+				"priority"         integer NOT NULL DEFAULT 0,
+				"partition"        integer NOT NULL DEFAULT 0
+			)
+		`)
+
+		store := SqliteStore{Table: "uuid_table"}
+		id := pee.UUIDGenerator()
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{Id: id, State: []byte("state data"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Id).To(Equal(id))
+
+			instance, err = store.Update(ctx, pee.SerializedInstance{Id: id, Version: 1, State: []byte("second state"), StateName: "B"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Version).To(Equal(2))
+
+			return nil
+		})
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Load(ctx, id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Id).To(Equal(id))
+			Expect(instance.State).To(Equal([]byte("second state")))
+
+			return nil
+		})
+	})
+
+	It("records failed attempts until the next update", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(store.RecordFailure(ctx, instance.Id, 1, pee.Failure{Error: "first"})).To(Succeed())
+			Expect(store.RecordFailure(ctx, instance.Id, 1, pee.Failure{Error: "second", Stack: "stack"})).To(Succeed())
+			Expect(store.RecordFailure(ctx, instance.Id, 2, pee.Failure{Error: "stale"})).To(MatchError(pee.ErrOptimisticLocking))
+
+			loaded, err := store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Attempts).To(Equal(2))
+			Expect(loaded.LastError).To(Equal("second"))
+			Expect(loaded.LastErrorStack).To(Equal("stack"))
+
+			updated, err := store.Update(ctx, pee.SerializedInstance{Id: instance.Id, Version: 1, State: []byte("next"), StateName: "B"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Attempts).To(Equal(0))
+
+			loaded, err = store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Attempts).To(Equal(0))
+			Expect(loaded.LastError).To(BeEmpty())
+			Expect(loaded.LastErrorStack).To(BeEmpty())
+
+			return nil
+		})
+	})
+
+	It("lists and clears dead lettered instances", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			healthy, err := store.Create(ctx, pee.SerializedInstance{State: []byte("healthy"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+
+			failed, err := store.Create(ctx, pee.SerializedInstance{State: []byte("failed"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(store.RecordFailure(ctx, failed.Id, 1, pee.Failure{Error: "broken", DeadLetter: true})).To(Succeed())
+
+			instances, err := store.List(ctx, pee.ListQuery{DeadLetter: pee.OnlyDeadLetters})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].Id).To(Equal(failed.Id))
+			Expect(instances[0].DeadLetter).To(BeTrue())
+			Expect(instances[0].LastError).To(Equal("broken"))
+
+			instances, err = store.List(ctx, pee.ListQuery{DeadLetter: pee.ExcludeDeadLetters})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].Id).To(Equal(healthy.Id))
+
+			Expect(store.ClearFailure(ctx, failed.Id, 2)).To(MatchError(pee.ErrOptimisticLocking))
+			Expect(store.ClearFailure(ctx, failed.Id, 1)).To(Succeed())
+
+			loaded, err := store.Load(ctx, failed.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.DeadLetter).To(BeFalse())
+			Expect(loaded.Attempts).To(Equal(0))
+			Expect(loaded.LastError).To(BeEmpty())
+
+			return nil
+		})
+	})
+
+	It("persists the deadline of an instance", func() {
+		deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A", Deadline: deadline})
+			Expect(err).ToNot(HaveOccurred())
+
+			loaded, err := store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Deadline).To(BeTemporally("==", deadline))
+
+			_, err = store.Update(ctx, pee.SerializedInstance{Id: instance.Id, Version: 1, State: []byte("next"), StateName: "B"})
+			Expect(err).ToNot(HaveOccurred())
+
+			loaded, err = store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Deadline).To(BeZero())
+
+			return nil
+		})
+	})
+
+	It("persists the result of an instance", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instance.Result).To(BeNil())
+
+			Expect(store.StoreResult(ctx, instance.Id, 2, []byte(`"stale"`))).To(MatchError(pee.ErrOptimisticLocking))
+			Expect(store.StoreResult(ctx, instance.Id, 1, []byte(`"result"`))).To(Succeed())
+
+			loaded, err := store.Load(ctx, instance.Id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Result).To(MatchJSON(`"result"`))
+
+			return nil
+		})
+	})
+
+	It("lists by priority and interleaves the values of a fairness label", func() {
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			create := func(state, tenant string, priority int) {
+				_, err := store.Create(ctx, pee.SerializedInstance{
+					State:     []byte(state),
+					StateName: "A",
+					Labels:    map[string]string{"tenant": tenant},
+					Priority:  priority,
+				})
+
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			create("a1", "a", 0)
+			create("a2", "a", 5)
+			create("a3", "a", 0)
+			create("b1", "b", 1)
+
+			states := func(instances []*pee.SerializedInstance) []string {
+				var states []string
+				for _, instance := range instances {
+					states = append(states, string(instance.State))
+				}
+
+				return states
+			}
+
+			instances, err := store.List(ctx, pee.ListQuery{Order: pee.OrderByPriority})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(states(instances)).To(Equal([]string{"a2", "b1", "a1", "a3"}))
+			Expect(instances[0].Priority).To(Equal(5))
+
+			instances, err = store.List(ctx, pee.ListQuery{FairnessLabel: "tenant", Limit: 3})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(states(instances)).To(Equal([]string{"a1", "b1", "a2"}))
+
+			instances, err = store.List(ctx, pee.ListQuery{Order: pee.OrderByPriority, FairnessLabel: "tenant"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(states(instances)).To(Equal([]string{"a2", "b1", "a1", "a3"}))
+
+			return nil
+		})
+	})
+
+	Context("when sharing limits", func() {
+		BeforeEach(func() {
+			db.MustExec(`
+				CREATE TABLE "my_table_slots" (
+					"key"        text      NOT NULL,
+					"slot"       integer   NOT NULL,
+					"owner"      text      NOT NULL,
+					"expires_at" TIMESTAMP NOT NULL,
+					PRIMARY KEY ("key", "slot")
+				)
+			`)
+
+			db.MustExec(`
+				CREATE TABLE "my_table_buckets" (
+					"key"         text             NOT NULL PRIMARY KEY,
+					"tokens"      double precision NOT NULL,
+					"refilled_at" bigint           NOT NULL
+				)
+			`)
+		})
+
+		It("hands out a limited number of slots", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				expiresAt := time.Now().Add(time.Hour)
+
+				Expect(store.AcquireSlot(ctx, "api", 2, "first", expiresAt)).To(BeTrue())
+				Expect(store.AcquireSlot(ctx, "api", 2, "second", expiresAt)).To(BeTrue())
+				Expect(store.AcquireSlot(ctx, "api", 2, "third", expiresAt)).To(BeFalse())
+
+				// slots of other keys are independent
+				Expect(store.AcquireSlot(ctx, "other", 1, "third", expiresAt)).To(BeTrue())
+
+				Expect(store.ReleaseSlot(ctx, "api", "first")).To(Succeed())
+				Expect(store.AcquireSlot(ctx, "api", 2, "third", expiresAt)).To(BeTrue())
+
+				return nil
+			})
+		})
+
+		It("takes over expired slots", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				Expect(store.AcquireSlot(ctx, "api", 1, "crashed", time.Now().Add(-time.Second))).To(BeTrue())
+				Expect(store.AcquireSlot(ctx, "api", 1, "next", time.Now().Add(time.Hour))).To(BeTrue())
+				Expect(store.AcquireSlot(ctx, "api", 1, "third", time.Now().Add(time.Hour))).To(BeFalse())
+
+				return nil
+			})
+		})
+
+		It("takes tokens from a refilling bucket", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				Expect(store.TakeToken(ctx, "api", 2, time.Hour)).To(BeTrue())
+				Expect(store.TakeToken(ctx, "api", 2, time.Hour)).To(BeTrue())
+				Expect(store.TakeToken(ctx, "api", 2, time.Hour)).To(BeFalse())
+
+				Expect(store.TakeToken(ctx, "fast", 1, 10*time.Millisecond)).To(BeTrue())
+				Expect(store.TakeToken(ctx, "fast", 1, 10*time.Millisecond)).To(BeFalse())
+
+				time.Sleep(20 * time.Millisecond)
+				Expect(store.TakeToken(ctx, "fast", 1, 10*time.Millisecond)).To(BeTrue())
+
+				return nil
+			})
+		})
+	})
+
+	Context("when coordinating partitions", func() {
+		BeforeEach(func() {
+			db.MustExec(`
+				CREATE TABLE "my_table_members" (
+					"group"      text      NOT NULL,
+					"worker"     text      NOT NULL,
+					"expires_at" TIMESTAMP NOT NULL,
+					PRIMARY KEY ("group", "worker")
+				)
+			`)
+
+			db.MustExec(`
+				CREATE TABLE "my_table_partitions" (
+					"group"      text      NOT NULL,
+					"partition"  integer   NOT NULL,
+					"worker"     text      NOT NULL,
+					"expires_at" TIMESTAMP NOT NULL,
+					PRIMARY KEY ("group", "partition")
+				)
+			`)
+		})
+
+		It("lists the live members of a group", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				Expect(store.Heartbeat(ctx, "runners", "b", time.Now().Add(time.Hour))).To(Equal([]string{"b"}))
+				Expect(store.Heartbeat(ctx, "runners", "a", time.Now().Add(time.Hour))).To(Equal([]string{"a", "b"}))
+				Expect(store.Heartbeat(ctx, "others", "c", time.Now().Add(time.Hour))).To(Equal([]string{"c"}))
+
+				// an expired member is removed with the next heartbeat
+				Expect(store.Heartbeat(ctx, "runners", "b", time.Now().Add(-time.Second))).To(Equal([]string{"a"}))
+
+				Expect(store.Leave(ctx, "runners", "a")).To(Succeed())
+				Expect(store.Heartbeat(ctx, "runners", "c", time.Now().Add(time.Hour))).To(Equal([]string{"c"}))
+
+				return nil
+			})
+		})
+
+		It("claims partitions for a single worker", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				expiresAt := time.Now().Add(time.Hour)
+
+				Expect(store.ClaimPartition(ctx, "runners", 0, "a", expiresAt)).To(BeTrue())
+				Expect(store.ClaimPartition(ctx, "runners", 0, "a", expiresAt)).To(BeTrue())
+				Expect(store.ClaimPartition(ctx, "runners", 0, "b", expiresAt)).To(BeFalse())
+				Expect(store.ClaimPartition(ctx, "runners", 1, "b", time.Now().Add(-time.Second))).To(BeTrue())
+
+				// the claim on partition 1 expired
+				Expect(store.ClaimPartition(ctx, "runners", 1, "a", expiresAt)).To(BeTrue())
+
+				Expect(store.ReleasePartition(ctx, "runners", 0, "b")).To(Succeed())
+				Expect(store.ClaimPartition(ctx, "runners", 0, "b", expiresAt)).To(BeFalse())
+
+				Expect(store.ReleasePartition(ctx, "runners", 0, "a")).To(Succeed())
+				Expect(store.ClaimPartition(ctx, "runners", 0, "b", expiresAt)).To(BeTrue())
+
+				// leaving releases all partitions of a worker
+				Expect(store.Leave(ctx, "runners", "a")).To(Succeed())
+				Expect(store.ClaimPartition(ctx, "runners", 1, "b", expiresAt)).To(BeTrue())
+
+				return nil
+			})
+		})
+
+		It("separates the groups of types sharing a table", func() {
+			db.MustExec(`ALTER TABLE "my_table" ADD COLUMN "type" text`)
+
+			typeA, typeB := store, store
+			typeA.Type = "A"
+			typeB.Type = "B"
+
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				expiresAt := time.Now().Add(time.Hour)
+
+				Expect(typeA.Heartbeat(ctx, pee.DefaultGroup, "a", expiresAt)).To(Equal([]string{"a"}))
+				Expect(typeB.Heartbeat(ctx, pee.DefaultGroup, "b", expiresAt)).To(Equal([]string{"b"}))
+
+				// both types claim all of their partitions
+				Expect(typeA.ClaimPartition(ctx, pee.DefaultGroup, 0, "a", expiresAt)).To(BeTrue())
+				Expect(typeB.ClaimPartition(ctx, pee.DefaultGroup, 0, "b", expiresAt)).To(BeTrue())
+
+				// leaving does not release the partitions of the other type
+				Expect(typeA.Leave(ctx, pee.DefaultGroup, "b")).To(Succeed())
+				Expect(typeB.ClaimPartition(ctx, pee.DefaultGroup, 0, "c", expiresAt)).To(BeFalse())
+				Expect(typeB.Heartbeat(ctx, pee.DefaultGroup, "b", expiresAt)).To(Equal([]string{"b"}))
+
+				return nil
+			})
+		})
+
+		It("lists instances by partition", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				for partition := 0; partition < 3; partition++ {
+					_, err := store.Create(ctx, pee.SerializedInstance{State: []byte("state data"), StateName: "A", Partition: partition})
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				instances, err := store.List(ctx, pee.ListQuery{Partitions: []int{0, 2}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(HaveLen(2))
+				Expect(instances[0].Partition).To(Equal(0))
+				Expect(instances[1].Partition).To(Equal(2))
+
+				return nil
+			})
+		})
+	})
+
+	It("persists schedules", func() {
+		db.MustExec(`
+			CREATE TABLE "my_table_schedules" (
+				"name"      text      NOT NULL PRIMARY KEY,
+				"last_tick" TIMESTAMP NOT NULL,
+				"version"   integer   NOT NULL
+			)
+		`)
+
+		created := time.Now().Add(-time.Hour).Truncate(time.Second)
+		tick := time.Now().Truncate(time.Second)
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			schedule, err := store.LoadSchedule(ctx, "hourly", created)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(schedule.LastTick).To(BeTemporally("==", created))
+			Expect(schedule.Version).To(Equal(1))
+
+			Expect(store.UpdateSchedule(ctx, "hourly", 1, tick)).To(Succeed())
+			Expect(store.UpdateSchedule(ctx, "hourly", 1, tick)).To(MatchError(pee.ErrOptimisticLocking))
+
+			// an existing schedule keeps its last tick
+			schedule, err = store.LoadSchedule(ctx, "hourly", created)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(schedule.LastTick).To(BeTemporally("==", tick))
+			Expect(schedule.Version).To(Equal(2))
+
+			return nil
+		})
+	})
+
+	It("creates many instances in the given order", func() {
+		var instances []pee.SerializedInstance
+		for idx := 0; idx < 1200; idx++ {
+			instances = append(instances, pee.SerializedInstance{
+				State:     []byte(fmt.Sprintf("state %d", idx)),
+				StateName: "A",
+				Labels:    map[string]string{"index": fmt.Sprint(idx)},
+			})
+		}
+
+		// instances with an id are inserted together, the others one by one
+		for idx := 600; idx < 610; idx++ {
+			instances[idx].Id = pee.IntId(4400 + idx)
+		}
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			created, err := store.CreateMany(ctx, instances)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(HaveLen(1200))
+
+			Expect(created[0].Id).To(Equal(pee.IntId(1)))
+			Expect(created[599].Id).To(Equal(pee.IntId(600)))
+			Expect(created[600].Id).To(Equal(pee.IntId(5000)))
+			Expect(created[609].Id).To(Equal(pee.IntId(5009)))
+			Expect(created[610].Id).To(Equal(pee.IntId(5010)))
+
+			for idx := range created {
+				instance := created[idx]
+				Expect(instance.Version).To(Equal(1))
+
+				loaded, err := store.Load(ctx, instance.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(loaded.State).To(Equal([]byte(fmt.Sprintf("state %d", idx))))
+				Expect(loaded.Labels).To(HaveKeyWithValue("index", fmt.Sprint(idx)))
+			}
+
+			return nil
+		})
+	})
+
+	Context("when multiple automata types share a table", func() {
+		var orders, payments SqliteStore
+
+		BeforeEach(func() {
+			db.MustExec(`ALTER TABLE "my_table" ADD COLUMN "type" text`)
+
+			orders = SqliteStore{Table: "my_table", Type: "orders"}
+			payments = SqliteStore{Table: "my_table", Type: "payments"}
+		})
+
+		It("refuses to load or update instances of another type", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				instance, err := orders.Create(ctx, pee.SerializedInstance{State: []byte("order"), StateName: "A"})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = payments.Load(ctx, instance.Id)
+				Expect(err).To(MatchError(pee.ErrNoSuchInstance))
+
+				_, err = payments.Update(ctx, pee.SerializedInstance{Id: instance.Id, Version: 1, State: []byte("payment"), StateName: "B"})
+				Expect(err).To(MatchError(pee.ErrOptimisticLocking))
+
+				loaded, err := orders.Load(ctx, instance.Id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(loaded.State).To(Equal([]byte("order")))
+
+				return nil
+			})
+		})
+
+		It("lists only instances of its own type", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				for _, stateName := range []string{"A", "B", "A"} {
+					_, err := orders.Create(ctx, pee.SerializedInstance{State: []byte("order"), StateName: stateName})
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				_, err := payments.Create(ctx, pee.SerializedInstance{State: []byte("payment"), StateName: "A"})
+				Expect(err).ToNot(HaveOccurred())
+
+				instances, err := orders.List(ctx, pee.ListQuery{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(HaveLen(3))
+
+				instances, err = orders.List(ctx, pee.ListQuery{StateNames: []string{"A"}, Limit: 1})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(HaveLen(1))
+				Expect(instances[0].Id).To(Equal(pee.IntId(1)))
+
+				instances, err = payments.List(ctx, pee.ListQuery{StateNames: []string{"A"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instances).To(HaveLen(1))
+				Expect(instances[0].State).To(Equal([]byte("payment")))
+
+				return nil
+			})
+		})
+	})
+
+	Context("when using a history table", func() {
+		BeforeEach(func() {
+			db.MustExec(`
+				CREATE TABLE "my_table_history" (
+					"instance_id" integer   NOT NULL,
+					"version"     integer   NOT NULL,
+					"state_name"  text      NOT NULL,
+					"state"       JSON      NOT NULL,
+					"created_at"  TIMESTAMP NOT NULL,
+					PRIMARY KEY ("instance_id", "version")
+				)
+			`)
+
+			store.History = pee_pg.HistoryTable
+		})
+
+		updateThreeTimes := func(ctx ql.TxContext) pee.InstanceId {
+			instance, err := store.Create(ctx, pee.SerializedInstance{State: []byte("v1"), StateName: "A"})
+			Expect(err).ToNot(HaveOccurred())
+
+			for version := 1; version <= 3; version++ {
+				state := []byte(fmt.Sprintf("v%d", version+1))
+				_, err := store.Update(ctx, pee.SerializedInstance{Id: instance.Id, Version: version, State: state, StateName: "B"})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			return instance.Id
+		}
+
+		It("records every state of an instance", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				id := updateThreeTimes(ctx)
+
+				entries, err := store.LoadHistory(ctx, id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(4))
+
+				for idx, entry := range entries {
+					Expect(entry.Version).To(Equal(idx + 1))
+					Expect(entry.State).To(Equal([]byte(fmt.Sprintf("v%d", idx+1))))
+				}
+
+				Expect(entries[0].StateName).To(Equal("A"))
+				Expect(entries[3].StateName).To(Equal("B"))
+
+				return nil
+			})
+		})
+
+		It("records the initial state of many new instances", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				created, err := store.CreateMany(ctx, []pee.SerializedInstance{
+					{State: []byte("first"), StateName: "A"},
+					{State: []byte("second"), StateName: "B"},
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				for _, instance := range created {
+					entries, err := store.LoadHistory(ctx, instance.Id)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Version).To(Equal(1))
+					Expect(entries[0].StateName).To(Equal(instance.StateName))
+					Expect(entries[0].State).To(Equal(instance.State))
+				}
+
+				return nil
+			})
+		})
+
+		It("keeps only a limited number of entries per instance", func() {
+			store.HistoryLimit = 2
+
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				id := updateThreeTimes(ctx)
+
+				entries, err := store.LoadHistory(ctx, id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(2))
+				Expect(entries[0].Version).To(Equal(3))
+				Expect(entries[1].Version).To(Equal(4))
+
+				return nil
+			})
+		})
+
+		It("prunes entries older than the retention time", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				id := updateThreeTimes(ctx)
+
+				pruned, err := store.PruneHistory(ctx, time.Now().Add(time.Second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pruned).To(Equal(4))
+
+				entries, err := store.LoadHistory(ctx, id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(BeEmpty())
+
+				return nil
+			})
+		})
+
+		It("purges instances together with their history", func() {
+			MustTransaction(db, func(ctx ql.TxContext) error {
+				id := updateThreeTimes(ctx)
+
+				purged, err := store.Purge(ctx, pee.PurgeQuery{StateNames: []string{"A"}, UpdatedBefore: time.Now().Add(time.Second)})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(purged).To(Equal(0))
+
+				purged, err = store.Purge(ctx, pee.PurgeQuery{StateNames: []string{"B"}, UpdatedBefore: time.Now().Add(time.Second)})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(purged).To(Equal(1))
+
+				_, err = store.Load(ctx, id)
+				Expect(err).To(MatchError(pee.ErrNoSuchInstance))
+
+				entries, err := store.LoadHistory(ctx, id)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(BeEmpty())
+
+				return nil
+			})
+		})
+	})
+
+	It("persists labels, state name, trace context and timestamps", func() {
+		var created *pee.SerializedInstance
+
+		MustTransaction(db, func(ctx ql.TxContext) (err error) {
+			created, err = store.Create(ctx, pee.SerializedInstance{
+				State:     []byte(`{"state":"A"}`),
+				StateName: "A",
+				Labels:    map[string]string{"tenant": "acme"},
+			})
+
+			return err
+		})
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			_, err := store.Update(ctx, pee.SerializedInstance{
+				Id:           created.Id,
+				Version:      1,
+				State:        []byte(`{"state":"B"}`),
+				StateName:    "B",
+				TraceContext: map[string]string{"traceparent": "00-trace-span-01"},
+			})
+
+			return err
+		})
+
+		MustTransaction(db, func(ctx ql.TxContext) error {
+			instance, err := store.Load(ctx, created.Id)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(instance.StateName).To(Equal("B"))
+			Expect(instance.Labels).To(Equal(map[string]string{"tenant": "acme"}))
+			Expect(instance.TraceContext).To(Equal(map[string]string{"traceparent": "00-trace-span-01"}))
+			Expect(instance.CreatedAt).To(BeTemporally("~", created.CreatedAt, time.Millisecond))
+			Expect(instance.UpdatedAt).To(BeTemporally(">=", instance.CreatedAt))
+
+			return nil
+		})
+	})
 })
 
 func MustTransaction(db *sqlx.DB, fn func(ctx ql.TxContext) error) {
@@ -0,0 +1,483 @@
+package pee
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps all instances in memory. It is part of the public API, so
+// that tests of packages building on pee, like metrics, tracing or the users of an Automata,
+// do not need a database. It implements all optional Store interfaces and can be used with
+// DummyRunInTx. It is safe for concurrent use, but does not support transactions: changes
+// made within a failing transaction are not rolled back. Do not use it in production.
+type MemoryStore struct {
+	mu        *sync.Mutex
+	instances map[InstanceId]SerializedInstance
+
+	// insertion order of the instances, breaks ties of instances created at the same time
+	sequences map[InstanceId]int
+	sequence  *int
+
+	// expiry of the held slots by key and owner
+	slots   map[string]map[string]time.Time
+	buckets map[string]*tokenBucket
+
+	// expiry of the memberships by group and worker
+	members map[string]map[string]time.Time
+	claims  map[string]map[int]partitionClaim
+
+	schedules map[string]SerializedSchedule
+}
+
+type partitionClaim struct {
+	worker    string
+	expiresAt time.Time
+}
+
+var _ Store[context.Context] = MemoryStore{}
+var _ ListStore[context.Context] = MemoryStore{}
+var _ CountStore[context.Context] = MemoryStore{}
+var _ FailureStore[context.Context] = MemoryStore{}
+var _ PurgeStore[context.Context] = MemoryStore{}
+var _ ResultStore[context.Context] = MemoryStore{}
+var _ LimitStore[context.Context] = MemoryStore{}
+var _ PartitionStore[context.Context] = MemoryStore{}
+var _ ScheduleStore[context.Context] = MemoryStore{}
+var _ BatchStore[context.Context] = MemoryStore{}
+
+func (m MemoryStore) Update(ctx context.Context, update SerializedInstance) (*SerializedInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[update.Id]
+	if !ok {
+		return nil, ErrNoSuchInstance
+	}
+
+	if instance.Version != update.Version {
+		return nil, ErrOptimisticLocking
+	}
+
+	instance.Version = update.Version + 1
+	instance.State = update.State
+	instance.StateName = update.StateName
+	instance.Deadline = update.Deadline
+	instance.Priority = update.Priority
+	instance.TraceContext = update.TraceContext
+	instance.Attempts = 0
+	instance.LastError = ""
+	instance.LastErrorStack = ""
+	instance.DeadLetter = false
+	instance.UpdatedAt = time.Now()
+
+	m.instances[instance.Id] = instance
+
+	return &instance, nil
+}
+
+func (m MemoryStore) Create(ctx context.Context, instance SerializedInstance) (*SerializedInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.create(instance, time.Now())
+}
+
+func (m MemoryStore) CreateMany(ctx context.Context, instances []SerializedInstance) ([]*SerializedInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	created := make([]*SerializedInstance, 0, len(instances))
+
+	for _, instance := range instances {
+		createdInstance, err := m.create(instance, now)
+		if err != nil {
+			// create none of the instances
+			for _, instance := range created {
+				delete(m.instances, instance.Id)
+				delete(m.sequences, instance.Id)
+			}
+
+			return nil, err
+		}
+
+		created = append(created, createdInstance)
+	}
+
+	return created, nil
+}
+
+// create creates the instance, the caller must hold the lock.
+func (m MemoryStore) create(instance SerializedInstance, now time.Time) (*SerializedInstance, error) {
+	// find the next free id, instances might have been purged
+	for next := len(m.instances) + 1; instance.Id == ""; next++ {
+		if _, exists := m.instances[IntId(next)]; !exists {
+			instance.Id = IntId(next)
+		}
+	}
+
+	if _, exists := m.instances[instance.Id]; exists {
+		return nil, makeErr("instance %q already exists", instance.Id)
+	}
+
+	instance.Version = 1
+	instance.CreatedAt = now
+	instance.UpdatedAt = now
+
+	m.instances[instance.Id] = instance
+
+	*m.sequence++
+	m.sequences[instance.Id] = *m.sequence
+
+	return &instance, nil
+}
+
+func (m MemoryStore) Load(ctx context.Context, id InstanceId) (*SerializedInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[id]
+	if !ok {
+		return nil, ErrNoSuchInstance
+	}
+
+	return &instance, nil
+}
+
+func (m MemoryStore) List(ctx context.Context, query ListQuery) ([]*SerializedInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var instances []*SerializedInstance
+
+	for _, instance := range m.instances {
+		if len(query.StateNames) > 0 && !contains(query.StateNames, instance.StateName) {
+			continue
+		}
+
+		if !query.DeadLetter.Matches(instance.DeadLetter) {
+			continue
+		}
+
+		if len(query.Partitions) > 0 && !contains(query.Partitions, instance.Partition) {
+			continue
+		}
+
+		instance := instance
+		instances = append(instances, &instance)
+	}
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		if query.Order == OrderByPriority && instances[i].Priority != instances[j].Priority {
+			return instances[i].Priority > instances[j].Priority
+		}
+
+		if !instances[i].CreatedAt.Equal(instances[j].CreatedAt) {
+			return instances[i].CreatedAt.Before(instances[j].CreatedAt)
+		}
+
+		return m.sequences[instances[i].Id] < m.sequences[instances[j].Id]
+	})
+
+	if query.FairnessLabel != "" {
+		instances = interleave(instances, query.FairnessLabel)
+	}
+
+	if query.Limit > 0 && len(instances) > query.Limit {
+		instances = instances[:query.Limit]
+	}
+
+	return instances, nil
+}
+
+func (m MemoryStore) RecordFailure(ctx context.Context, id InstanceId, version int, failure Failure) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[id]
+	if !ok {
+		return ErrNoSuchInstance
+	}
+
+	if instance.Version != version {
+		return ErrOptimisticLocking
+	}
+
+	instance.Attempts++
+	instance.LastError = failure.Error
+	instance.LastErrorStack = failure.Stack
+	instance.DeadLetter = failure.DeadLetter
+	m.instances[id] = instance
+
+	return nil
+}
+
+func (m MemoryStore) ClearFailure(ctx context.Context, id InstanceId, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[id]
+	if !ok {
+		return ErrNoSuchInstance
+	}
+
+	if instance.Version != version {
+		return ErrOptimisticLocking
+	}
+
+	instance.Attempts = 0
+	instance.LastError = ""
+	instance.LastErrorStack = ""
+	instance.DeadLetter = false
+	m.instances[id] = instance
+
+	return nil
+}
+
+func (m MemoryStore) Purge(ctx context.Context, query PurgeQuery) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int
+
+	for id, instance := range m.instances {
+		if contains(query.StateNames, instance.StateName) && instance.UpdatedAt.Before(query.UpdatedBefore) {
+			delete(m.instances, id)
+			delete(m.sequences, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m MemoryStore) StoreResult(ctx context.Context, id InstanceId, version int, result []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[id]
+	if !ok {
+		return ErrNoSuchInstance
+	}
+
+	if instance.Version != version {
+		return ErrOptimisticLocking
+	}
+
+	instance.Result = result
+	m.instances[id] = instance
+
+	return nil
+}
+
+func (m MemoryStore) CountByState(ctx context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := map[string]int{}
+
+	for _, instance := range m.instances {
+		counts[instance.StateName]++
+	}
+
+	return counts, nil
+}
+
+func (m MemoryStore) AcquireSlot(ctx context.Context, key string, slots int, owner string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	held := m.slots[key]
+	if held == nil {
+		held = map[string]time.Time{}
+		m.slots[key] = held
+	}
+
+	now := time.Now()
+
+	for heldBy, expiry := range held {
+		if !expiry.After(now) {
+			delete(held, heldBy)
+		}
+	}
+
+	if _, ok := held[owner]; !ok && len(held) >= slots {
+		return false, nil
+	}
+
+	held[owner] = expiresAt
+
+	return true, nil
+}
+
+func (m MemoryStore) ReleaseSlot(ctx context.Context, key string, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.slots[key], owner)
+
+	return nil
+}
+
+func (m MemoryStore) TakeToken(ctx context.Context, key string, limit int, per time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket := m.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{}
+		m.buckets[key] = bucket
+	}
+
+	return bucket.take(limit, per, time.Now()), nil
+}
+
+func (m MemoryStore) Heartbeat(ctx context.Context, group string, worker string, expiresAt time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.members[group]
+	if members == nil {
+		members = map[string]time.Time{}
+		m.members[group] = members
+	}
+
+	members[worker] = expiresAt
+
+	now := time.Now()
+
+	var live []string
+	for member, expiry := range members {
+		if !expiry.After(now) {
+			delete(members, member)
+			continue
+		}
+
+		live = append(live, member)
+	}
+
+	sort.Strings(live)
+
+	return live, nil
+}
+
+func (m MemoryStore) Leave(ctx context.Context, group string, worker string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.members[group], worker)
+
+	for partition, claim := range m.claims[group] {
+		if claim.worker == worker {
+			delete(m.claims[group], partition)
+		}
+	}
+
+	return nil
+}
+
+func (m MemoryStore) ClaimPartition(ctx context.Context, group string, partition int, worker string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	claims := m.claims[group]
+	if claims == nil {
+		claims = map[int]partitionClaim{}
+		m.claims[group] = claims
+	}
+
+	if claim, ok := claims[partition]; ok && claim.worker != worker && claim.expiresAt.After(time.Now()) {
+		return false, nil
+	}
+
+	claims[partition] = partitionClaim{worker: worker, expiresAt: expiresAt}
+
+	return true, nil
+}
+
+func (m MemoryStore) ReleasePartition(ctx context.Context, group string, partition int, worker string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if claim, ok := m.claims[group][partition]; ok && claim.worker == worker {
+		delete(m.claims[group], partition)
+	}
+
+	return nil
+}
+
+func (m MemoryStore) LoadSchedule(ctx context.Context, name string, lastTick time.Time) (*SerializedSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule, ok := m.schedules[name]
+	if !ok {
+		schedule = SerializedSchedule{Name: name, LastTick: lastTick, Version: 1}
+		m.schedules[name] = schedule
+	}
+
+	return &schedule, nil
+}
+
+func (m MemoryStore) UpdateSchedule(ctx context.Context, name string, version int, lastTick time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule, ok := m.schedules[name]
+	if !ok || schedule.Version != version {
+		return ErrOptimisticLocking
+	}
+
+	schedule.LastTick = lastTick
+	schedule.Version++
+	m.schedules[name] = schedule
+
+	return nil
+}
+
+// interleave orders the instances round robin across the values of the given label.
+// The order of instances with the same value is kept.
+func interleave(instances []*SerializedInstance, label string) []*SerializedInstance {
+	ranks := map[*SerializedInstance]int{}
+	counts := map[string]int{}
+
+	for _, instance := range instances {
+		value := instance.Labels[label]
+		ranks[instance] = counts[value]
+		counts[value]++
+	}
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		return ranks[instances[i]] < ranks[instances[j]]
+	})
+
+	return instances
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewMemoryStore creates a new and empty MemoryStore, see MemoryStore.
+func NewMemoryStore() Store[context.Context] {
+	return MemoryStore{
+		mu:        &sync.Mutex{},
+		instances: map[InstanceId]SerializedInstance{},
+		sequences: map[InstanceId]int{},
+		sequence:  new(int),
+		slots:     map[string]map[string]time.Time{},
+		buckets:   map[string]*tokenBucket{},
+		members:   map[string]map[string]time.Time{},
+		claims:    map[string]map[int]partitionClaim{},
+		schedules: map[string]SerializedSchedule{},
+	}
+}
@@ -0,0 +1,119 @@
+package pee
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State timeouts", func() {
+	type StateA struct {
+		State `name:"A"`
+		Hang  bool
+	}
+
+	type StateB struct {
+		State `name:"B"`
+	}
+
+	type StateTimedOut struct {
+		State `name:"TimedOut"`
+	}
+
+	var a *Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		a = New[string](NewMemoryStore())
+
+		AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		AddFinalState(a, func(ctx context.Context, state StateTimedOut) (string, error) {
+			return "timed out", nil
+		})
+	})
+
+	handler := func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+		if state.Hang {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		return Transition[context.Context](StateB{}).AsTuple()
+	}
+
+	It("returns the handlers error after the timeout", func() {
+		AddState(a, handler, WithTimeout(10*time.Millisecond))
+
+		instance, err := a.Start(ctx, StateA{Hang: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+
+		var handlerErr *HandlerError
+		Expect(err).To(BeAssignableToTypeOf(handlerErr))
+	})
+
+	It("moves the instance to the timeout state", func() {
+		AddState(a, handler, WithTimeout(10*time.Millisecond), WithTimeoutState(StateTimedOut{}))
+
+		instance, err := a.Start(ctx, StateA{Hang: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("timed out"))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("TimedOut"))
+	})
+
+	It("does not interfere with handlers that finish in time", func() {
+		AddState(a, handler, WithTimeout(time.Second), WithTimeoutState(StateTimedOut{}))
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+	})
+
+	It("applies the transition of a handler that ignores the timeout", func() {
+		AddState(a, func(ctx context.Context, state StateA) (*StateTransition[context.Context], error) {
+			time.Sleep(20 * time.Millisecond)
+			return Transition[context.Context](StateB{}).AsTuple()
+		}, WithTimeout(10*time.Millisecond), WithTimeoutState(StateTimedOut{}))
+
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := a.Execute(ctx, DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("done"))
+	})
+
+	It("does not move the instance if the callers context is done", func() {
+		AddState(a, handler, WithTimeout(time.Second), WithTimeoutState(StateTimedOut{}))
+
+		instance, err := a.Start(ctx, StateA{Hang: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		callerCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err = a.Execute(callerCtx, DummyRunInTx, instance)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.StateName).To(Equal("A"))
+	})
+})
@@ -0,0 +1,99 @@
+package pee_otel
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"pee"
+)
+
+const (
+	AttrInstanceId      = attribute.Key("pee.instance.id")
+	AttrInstanceVersion = attribute.Key("pee.instance.version")
+	AttrStateFrom       = attribute.Key("pee.state.from")
+	AttrStateTo         = attribute.Key("pee.state.to")
+)
+
+// Tracing creates spans for the execution of an Automata.
+// Use Instrument to attach the tracing to an Automata.
+type Tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates a new Tracing using the given tracer. The trace context of the instances
+// is persisted using the W3C trace context format.
+func New(tracer trace.Tracer) *Tracing {
+	return &Tracing{
+		tracer:     tracer,
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Instrument attaches the tracing to the given Automata. This creates a span for every
+// call to Execute, and a child span for every handler, transition and final transform.
+// The trace context of every transition is persisted with the instance. The span of a
+// later execution of the same instance is linked to the span of its last transition.
+func Instrument[TxContext context.Context, R any](a *pee.Automata[TxContext, R], t *Tracing) {
+	a.Use(t.Middleware()).WithTraceContext(t.Inject)
+}
+
+// Middleware returns a pee.Middleware that creates a span for each step.
+func (t *Tracing) Middleware() pee.Middleware {
+	return func(ctx context.Context, step pee.Step, next func(ctx context.Context) error) error {
+		opts := []trace.SpanStartOption{
+			trace.WithAttributes(
+				AttrInstanceId.String(step.Instance.Id.String()),
+				AttrInstanceVersion.Int(step.Instance.Version),
+				AttrStateFrom.String(step.Instance.StateName),
+			),
+		}
+
+		// link to the span of the previous transition of this instance
+		if step.Kind == pee.StepExecute {
+			if previous := t.extract(step.Instance.TraceContext); previous.IsValid() {
+				opts = append(opts, trace.WithLinks(trace.Link{SpanContext: previous}))
+			}
+		}
+
+		ctx, span := t.tracer.Start(ctx, "pee."+step.Kind.String(), opts...)
+		defer span.End()
+
+		err := next(ctx)
+
+		if result, ok := step.Result(); ok {
+			span.SetAttributes(AttrStateTo.String(result.StateName))
+		}
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		return err
+	}
+}
+
+// Inject returns the trace context of the span in the given context,
+// see pee.Automata.WithTraceContext.
+func (t *Tracing) Inject(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+
+	return carrier
+}
+
+func (t *Tracing) extract(traceContext map[string]string) trace.SpanContext {
+	if len(traceContext) == 0 {
+		return trace.SpanContext{}
+	}
+
+	ctx := t.propagator.Extract(context.Background(), propagation.MapCarrier(traceContext))
+	return trace.SpanContextFromContext(ctx)
+}
@@ -0,0 +1,111 @@
+package pee_otel
+
+import (
+	"context"
+	"github.com/onsi/ginkgo/v2/types"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"pee"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRunSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OpenTelemetry tracing specs", types.ReporterConfig{Verbose: true})
+}
+
+var _ = Describe("Tracing", func() {
+	type StateA struct {
+		pee.State `name:"A"`
+	}
+
+	type StateB struct {
+		pee.State `name:"B"`
+	}
+
+	var exporter *tracetest.InMemoryExporter
+	var a *pee.Automata[context.Context, string]
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+		a = pee.New[string](pee.NewMemoryStore())
+
+		pee.AddState(a, func(ctx context.Context, state StateA) (*pee.StateTransition[context.Context], error) {
+			return a.NewTransition(StateB{}).AsTuple()
+		})
+
+		pee.AddFinalState(a, func(ctx context.Context, state StateB) (string, error) {
+			return "done", nil
+		})
+
+		Instrument(a, New(provider.Tracer("test")))
+	})
+
+	attributesOf := func(span tracetest.SpanStub) map[string]any {
+		attributes := map[string]any{}
+		for _, attr := range span.Attributes {
+			attributes[string(attr.Key)] = attr.Value.AsInterface()
+		}
+
+		return attributes
+	}
+
+	It("creates spans for the execution and every step", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, pee.DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		spans := exporter.GetSpans()
+
+		var names []string
+		for _, span := range spans {
+			names = append(names, span.Name)
+		}
+
+		Expect(names).To(Equal([]string{"pee.handler", "pee.transition", "pee.final", "pee.execute"}))
+
+		execute := spans[3]
+		for _, span := range spans[:3] {
+			Expect(span.Parent.SpanID()).To(Equal(execute.SpanContext.SpanID()))
+		}
+
+		Expect(attributesOf(spans[1])).To(Equal(map[string]any{
+			"pee.instance.id":      "1",
+			"pee.instance.version": int64(1),
+			"pee.state.from":       "A",
+			"pee.state.to":         "B",
+		}))
+	})
+
+	It("links later executions to the previous transition", func() {
+		instance, err := a.Start(ctx, StateA{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = a.Execute(ctx, pee.DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		transition := exporter.GetSpans()[1]
+		exporter.Reset()
+
+		instance, err = a.Load(ctx, instance.Id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instance.TraceContext).To(HaveKey("traceparent"))
+
+		_, err = a.Execute(ctx, pee.DummyRunInTx, instance)
+		Expect(err).ToNot(HaveOccurred())
+
+		execute := exporter.GetSpans()[1]
+		Expect(execute.Name).To(Equal("pee.execute"))
+		Expect(execute.Links).To(HaveLen(1))
+		Expect(execute.Links[0].SpanContext.SpanID()).To(Equal(transition.SpanContext.SpanID()))
+	})
+})
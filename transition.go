@@ -15,7 +15,7 @@ type InfallibleAction[TxContext context.Context] func(ctx TxContext)
 // You can register multiple Action with a transition that are executed in the
 // same database transaction that also updates the Automata.
 // If any of the actions return an error, it will cancel the transaction
-// (and in turn will cancel the transition). The error will be returned without wrapping.
+// (and in turn will cancel the transition). The error will be returned wrapped in a TransitionError.
 type StateTransition[TxContext context.Context] struct {
 	// set by one of the actions
 	nextState State
@@ -26,6 +26,9 @@ type StateTransition[TxContext context.Context] struct {
 	// a state transition can only be run once and will
 	// fail if it is run a second time.
 	executed bool
+
+	// the new priority of the instance, if changed
+	priority *int
 }
 
 // Transition initializes a new transition to the provided next State.
@@ -81,6 +84,13 @@ func (t *StateTransition[TxContext]) WithInfallibleAction(action InfallibleActio
 	)
 }
 
+// WithPriority changes the priority of the Instance with this StateTransition.
+// See WithPriority for details on priorities.
+func (t *StateTransition[TxContext]) WithPriority(priority int) *StateTransition[TxContext] {
+	t.priority = &priority
+	return t
+}
+
 // AsTuple provides some convenience when you need to return a StateTransition and an error.
 // It is the same as returning `return self, nil`
 func (t *StateTransition[TxContext]) AsTuple() (*StateTransition[TxContext], error) {
@@ -106,3 +116,10 @@ func (t *StateTransition[TxContext]) applyIn(ctx TxContext) (State, error) {
 	// after applying the actions we can get the next state from the transition.
 	return t.nextState, nil
 }
+
+// reset allows the transition to be applied again, after the transaction
+// it was applied in was rolled back.
+func (t *StateTransition[TxContext]) reset() {
+	t.executed = false
+	t.nextState = nil
+}